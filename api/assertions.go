@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// -------------------- ASSERTIONS --------------------
+
+const (
+	AssertStatus = "status"
+	AssertBody   = "body"
+	AssertHeader = "header"
+	AssertJSON   = "json"
+
+	maxBodySize = 1 << 20
+)
+
+type Assertion struct {
+	Source   string `json:"source"`
+	Property string `json:"property,omitempty"`
+	Op       string `json:"op,omitempty"`
+	Target   any    `json:"target,omitempty"`
+	Severity string `json:"severity,omitempty"`
+}
+
+type AssertionResult struct {
+	Source   string `json:"source"`
+	Property string `json:"property,omitempty"`
+	Op       string `json:"op"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Passed   bool   `json:"passed"`
+	Severity string `json:"severity"`
+	Message  string `json:"message,omitempty"`
+}
+
+// The status check probeHTTP always applied before assertions existed.
+var defaultStatusAssertion = Assertion{Source: AssertStatus, Op: "in", Target: "200-399"}
+
+// maxActualSize bounds the value a failed assertion reports. Results end up
+// in public payloads, so passing ones report none and the body never does.
+const maxActualSize = 120
+
+func evaluateAssertions(assertions []Assertion, resp *http.Response, body []byte) []AssertionResult {
+	if !slices.ContainsFunc(assertions, func(a Assertion) bool { return a.Source == AssertStatus }) {
+		assertions = append([]Assertion{defaultStatusAssertion}, assertions...)
+	}
+
+	var doc any
+	var docErr error
+	parsed := false
+
+	results := make([]AssertionResult, 0, len(assertions))
+	for _, a := range assertions {
+		res := AssertionResult{
+			Source:   a.Source,
+			Property: a.Property,
+			Op:       a.op(),
+			Expected: stringify(a.Target),
+			Severity: a.severity(),
+		}
+
+		var actual string
+		switch a.Source {
+		case AssertStatus:
+			actual = strconv.Itoa(resp.StatusCode)
+			res.Passed, res.Message = compare(res.Op, resp.StatusCode, true, a.Target)
+
+		case AssertBody:
+			res.Passed, res.Message = compare(res.Op, string(body), true, a.Target)
+			if !res.Passed && res.Message == "" {
+				res.Message = "not met"
+			}
+
+		case AssertHeader:
+			values, ok := resp.Header[http.CanonicalHeaderKey(a.Property)]
+			actual = strings.Join(values, ", ")
+			res.Passed, res.Message = compare(res.Op, actual, ok, a.Target)
+
+		case AssertJSON:
+			if !parsed {
+				docErr = json.Unmarshal(body, &doc)
+				parsed = true
+			}
+			if docErr != nil {
+				res.Message = "body is not valid JSON"
+				break
+			}
+			value, ok := lookupJSONPath(doc, a.Property)
+			if ok {
+				actual = stringify(value)
+			}
+			res.Passed, res.Message = compare(res.Op, value, ok, a.Target)
+
+		default:
+			res.Message = fmt.Sprintf("unknown assertion source %q", a.Source)
+		}
+
+		if !res.Passed {
+			res.Actual = truncate(actual, maxActualSize)
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// assertionState folds assertion results into a probe state. A failed
+// "warn" assertion only degrades the monitor, anything else takes it down.
+func assertionState(results []AssertionResult) (string, []string) {
+	state := hr.Up
+	failures := []string{}
+	for _, r := range results {
+		if r.Passed {
+			continue
+		}
+		failures = append(failures, r.String())
+		if r.Severity == hr.Warn {
+			if state == hr.Up {
+				state = hr.Warn
+			}
+			continue
+		}
+		state = hr.Down
+	}
+	return state, failures
+}
+
+func (r AssertionResult) String() string {
+	subject := r.Source
+	if r.Property != "" {
+		subject = fmt.Sprintf("%s %s", r.Source, r.Property)
+	}
+	msg := fmt.Sprintf("%s %s %s", subject, r.Op, r.Expected)
+	details := []string{}
+	if r.Message != "" {
+		details = append(details, r.Message)
+	}
+	if r.Actual != "" {
+		details = append(details, "got "+r.Actual)
+	}
+	if len(details) > 0 {
+		msg = fmt.Sprintf("%s: %s", msg, strings.Join(details, ", "))
+	}
+	return strings.TrimSpace(msg)
+}
+
+func (a Assertion) op() string {
+	if a.Op != "" {
+		return a.Op
+	}
+	switch a.Source {
+	case AssertStatus:
+		return "in"
+	case AssertBody:
+		return "contains"
+	case AssertHeader, AssertJSON:
+		if a.Target == nil {
+			return "exists"
+		}
+	}
+	return "eq"
+}
+
+func (a Assertion) severity() string {
+	if strings.EqualFold(a.Severity, hr.Warn) {
+		return hr.Warn
+	}
+	return hr.Down
+}
+
+// compare applies op to actual. found reports whether actual was present at
+// all (a missing header or JSON path), which only "notExists" accepts. The
+// message never quotes actual; the caller decides how much of it to show.
+func compare(op string, actual any, found bool, target any) (bool, string) {
+	switch op {
+	case "exists":
+		return found, ternary(found, "", "not found")
+	case "notExists":
+		return !found, ternary(found, "present", "")
+	}
+
+	if !found {
+		return false, "not found"
+	}
+
+	a := stringify(actual)
+	t := stringify(target)
+
+	switch op {
+	case "eq":
+		if af, ok := toFloat(actual); ok {
+			if tf, ok := toFloat(target); ok {
+				return af == tf, ""
+			}
+		}
+		return a == t, ""
+
+	case "neq":
+		return a != t, ""
+
+	case "contains", "notContains":
+		var ok bool
+		if list, isList := actual.([]any); isList {
+			ok = slices.ContainsFunc(list, func(v any) bool { return stringify(v) == t })
+		} else {
+			ok = strings.Contains(a, t)
+		}
+		if op == "notContains" {
+			return !ok, ternary(!ok, "", "present")
+		}
+		return ok, ternary(ok, "", "missing")
+
+	case "matches", "notMatches":
+		re, err := regexp.Compile(t)
+		if err != nil {
+			return false, fmt.Sprintf("invalid regex: %s", err.Error())
+		}
+		ok := re.MatchString(a)
+		if op == "notMatches" {
+			return !ok, ternary(!ok, "", "matched")
+		}
+		return ok, ternary(ok, "", "no match")
+
+	case "lt", "lte", "gt", "gte":
+		af, ok := toFloat(actual)
+		if !ok {
+			return false, "not a number"
+		}
+		tf, ok := toFloat(target)
+		if !ok {
+			return false, fmt.Sprintf("target %s is not a number", t)
+		}
+		var pass bool
+		switch op {
+		case "lt":
+			pass = af < tf
+		case "lte":
+			pass = af <= tf
+		case "gt":
+			pass = af > tf
+		case "gte":
+			pass = af >= tf
+		}
+		return pass, ""
+
+	case "in":
+		code, ok := toFloat(actual)
+		if !ok {
+			return false, "not a number"
+		}
+		pass, err := matchStatusCodes(int(code), target)
+		if err != nil {
+			return false, err.Error()
+		}
+		return pass, ""
+	}
+
+	return false, fmt.Sprintf("unknown operator %q", op)
+}
+
+// matchStatusCodes accepts exact codes ("204"), classes ("2xx") and ranges
+// ("200-299"), either comma separated or as a list.
+func matchStatusCodes(code int, target any) (bool, error) {
+	var specs []string
+	switch t := target.(type) {
+	case []any:
+		for _, v := range t {
+			specs = append(specs, stringify(v))
+		}
+	default:
+		specs = strings.Split(stringify(t), ",")
+	}
+
+	for _, spec := range specs {
+		spec = strings.ToLower(strings.TrimSpace(spec))
+		switch {
+		case spec == "":
+			continue
+
+		case len(spec) == 3 && strings.HasSuffix(spec, "xx"):
+			class, err := strconv.Atoi(spec[:1])
+			if err != nil {
+				return false, fmt.Errorf("invalid status class %q", spec)
+			}
+			if code/100 == class {
+				return true, nil
+			}
+
+		case strings.Contains(spec, "-"):
+			lo, hi, _ := strings.Cut(spec, "-")
+			min, err1 := strconv.Atoi(strings.TrimSpace(lo))
+			max, err2 := strconv.Atoi(strings.TrimSpace(hi))
+			if err1 != nil || err2 != nil {
+				return false, fmt.Errorf("invalid status range %q", spec)
+			}
+			if code >= min && code <= max {
+				return true, nil
+			}
+
+		default:
+			exact, err := strconv.Atoi(spec)
+			if err != nil {
+				return false, fmt.Errorf("invalid status code %q", spec)
+			}
+			if code == exact {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// lookupJSONPath resolves a small JSONPath subset: "$.data.items[0].id",
+// "data.items.0.id" and "$['key with spaces']" all work.
+func lookupJSONPath(doc any, path string) (any, bool) {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "$")
+
+	cur := doc
+	for len(path) > 0 {
+		var key string
+		switch path[0] {
+		case '.':
+			path = path[1:]
+			continue
+
+		case '[':
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return nil, false
+			}
+			key = strings.Trim(path[1:end], `'"`)
+			path = path[end+1:]
+
+		default:
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			key = path[:end]
+			path = path[end:]
+		}
+
+		switch node := cur.(type) {
+		case map[string]any:
+			v, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			cur = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func stringify(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case int:
+		return strconv.Itoa(t)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func toFloat(v any) (float64, bool) {
+	switch t := v.(type) {
+	case int:
+		return float64(t), true
+	case float64:
+		return t, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "…"
+}
+
+func ternary[T any](cond bool, a, b T) T {
+	if cond {
+		return a
+	}
+	return b
+}
+
+func validateAssertions(assertions []Assertion) error {
+	for i, a := range assertions {
+		op := a.op()
+		switch a.Source {
+		case AssertStatus, AssertBody:
+		case AssertHeader, AssertJSON:
+			if strings.TrimSpace(a.Property) == "" {
+				return fmt.Errorf("assertion %d: %s assertions need a property", i, a.Source)
+			}
+		default:
+			return fmt.Errorf("assertion %d: unknown source %q", i, a.Source)
+		}
+
+		switch op {
+		case "exists", "notExists", "eq", "neq", "contains", "notContains", "lt", "lte", "gt", "gte":
+		case "matches", "notMatches":
+			if _, err := regexp.Compile(stringify(a.Target)); err != nil {
+				return fmt.Errorf("assertion %d: %w", i, err)
+			}
+		case "in":
+			if _, err := matchStatusCodes(0, a.Target); err != nil {
+				return fmt.Errorf("assertion %d: %w", i, err)
+			}
+		default:
+			return fmt.Errorf("assertion %d: unknown operator %q", i, op)
+		}
+
+		if a.Severity != "" && a.severity() != hr.Warn && !strings.EqualFold(a.Severity, hr.Down) {
+			return fmt.Errorf("assertion %d: severity must be %q or %q", i, hr.Down, hr.Warn)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+// -------------------- AUTH --------------------
+
+const (
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+	AuthDigest = "digest"
+
+	redacted = "[REDACTED]"
+	// Credentials shorter than minRedactedSize are not redacted: replacing
+	// "test" or "200" everywhere would blank out hostnames and status codes.
+	minRedactedSize = 6
+)
+
+var sensitiveHeaders = []string{"authorization", "proxy-authorization", "cookie", "token", "secret", "api-key", "apikey", "password"}
+
+func (re HttpRequest) authType() string {
+	switch strings.ToLower(strings.TrimSpace(re.AuthType)) {
+	case AuthBasic:
+		return AuthBasic
+	case AuthBearer:
+		return AuthBearer
+	case AuthDigest:
+		return AuthDigest
+	case "":
+		if re.Token != "" {
+			return AuthBearer
+		}
+		if re.Username != "" {
+			return AuthBasic
+		}
+	}
+	return ""
+}
+
+// doWithAuth sends r with the credentials configured on re. Digest auth
+// needs the server challenge first, so it costs an extra round trip.
+func doWithAuth(client *http.Client, r *http.Request, re HttpRequest) (*http.Response, error) {
+	switch re.authType() {
+	case AuthBasic:
+		r.SetBasicAuth(re.Username, re.Password)
+	case AuthBearer:
+		r.Header.Set("Authorization", "Bearer "+re.Token)
+	case AuthDigest:
+		return doDigest(client, r, re)
+	}
+	return client.Do(r)
+}
+
+func doDigest(client *http.Client, r *http.Request, re HttpRequest) (*http.Response, error) {
+	retry := r.Clone(r.Context())
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+
+	resp, err := client.Do(r)
+	if err != nil || resp.StatusCode != StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if !strings.HasPrefix(strings.ToLower(challenge), "digest ") {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	authorization, err := digestAuthorization(challenge, retry.Method, retry.URL.RequestURI(), re.Username, re.Password)
+	if err != nil {
+		return nil, err
+	}
+	retry.Header.Set("Authorization", authorization)
+
+	return client.Do(retry)
+}
+
+// digestAuthorization answers an RFC 7616 challenge with qop=auth, or the
+// RFC 2069 form when the server offers no qop.
+func digestAuthorization(challenge, method, uri, username, password string) (string, error) {
+	params := parseAuthParams(challenge[len("digest "):])
+
+	algorithm := params["algorithm"]
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+
+	var newHash func() hash.Hash
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+
+	h := func(s string) string {
+		d := newHash()
+		d.Write([]byte(s))
+		return hex.EncodeToString(d.Sum(nil))
+	}
+
+	realm, nonce := params["realm"], params["nonce"]
+	if nonce == "" {
+		return "", fmt.Errorf("digest challenge without nonce")
+	}
+
+	cnonceBytes := make([]byte, 8)
+	_, _ = rand.Read(cnonceBytes)
+	cnonce := hex.EncodeToString(cnonceBytes)
+	nc := "00000001"
+
+	ha1 := h(fmt.Sprintf("%s:%s:%s", username, realm, password))
+	if strings.HasSuffix(strings.ToUpper(algorithm), "-SESS") {
+		ha1 = h(fmt.Sprintf("%s:%s:%s", ha1, nonce, cnonce))
+	}
+	ha2 := h(fmt.Sprintf("%s:%s", method, uri))
+
+	qop := ""
+	for q := range strings.SplitSeq(params["qop"], ",") {
+		if strings.TrimSpace(q) == "auth" {
+			qop = "auth"
+		}
+	}
+
+	var response string
+	if qop != "" {
+		response = h(fmt.Sprintf("%s:%s:%s:%s:%s:%s", ha1, nonce, nc, cnonce, qop, ha2))
+	} else {
+		response = h(fmt.Sprintf("%s:%s:%s", ha1, nonce, ha2))
+	}
+
+	parts := []string{
+		fmt.Sprintf(`username="%s"`, username),
+		fmt.Sprintf(`realm="%s"`, realm),
+		fmt.Sprintf(`nonce="%s"`, nonce),
+		fmt.Sprintf(`uri="%s"`, uri),
+		fmt.Sprintf(`algorithm=%s`, algorithm),
+		fmt.Sprintf(`response="%s"`, response),
+	}
+	if qop != "" {
+		parts = append(parts, "qop="+qop, "nc="+nc, fmt.Sprintf(`cnonce="%s"`, cnonce))
+	}
+	if opaque, ok := params["opaque"]; ok {
+		parts = append(parts, fmt.Sprintf(`opaque="%s"`, opaque))
+	}
+
+	return "Digest " + strings.Join(parts, ", "), nil
+}
+
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,")
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " ")
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, s = rest[1:], ""
+			} else {
+				value, s = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, s, _ = strings.Cut(rest, ",")
+		}
+		params[key] = strings.TrimSpace(value)
+	}
+	return params
+}
+
+// -------------------- REDACTION --------------------
+
+// secrets lists every credential configured on the monitor, so they can be
+// scrubbed from anything that leaves the probe (logs, SSE, KV).
+func (re HttpRequest) secrets() []string {
+	out := []string{}
+	for _, s := range []string{re.Password, re.Token} {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	for key, value := range re.Headers {
+		if value != "" && isSensitiveHeader(key) {
+			out = append(out, value)
+		}
+	}
+
+	// Protocol settings carry credentials too: a heartbeat token, gRPC
+	// metadata, the headers of transaction steps.
+	var config any
+	if len(re.Config) > 0 && json.Unmarshal(re.Config, &config) == nil {
+		out = appendConfigSecrets(out, config)
+	}
+	return out
+}
+
+func appendConfigSecrets(out []string, v any) []string {
+	switch node := v.(type) {
+	case map[string]any:
+		for key, value := range node {
+			if s, ok := value.(string); ok && s != "" && isSensitiveHeader(key) {
+				out = append(out, s)
+				continue
+			}
+			out = appendConfigSecrets(out, value)
+		}
+	case []any:
+		for _, value := range node {
+			out = appendConfigSecrets(out, value)
+		}
+	}
+	return out
+}
+
+func isSensitiveHeader(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveHeaders {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func redact(s string, secrets []string) string {
+	for _, secret := range secrets {
+		if len(secret) >= minRedactedSize {
+			s = strings.ReplaceAll(s, secret, redacted)
+		}
+	}
+	return s
+}
+
+func redactProbeResult(re HttpRequest, res ProbeResult) ProbeResult {
+	return redactSecrets(res, re.secrets())
+}
+
+func redactSecrets(res ProbeResult, secrets []string) ProbeResult {
+	if len(secrets) == 0 {
+		return res
+	}
+
+	res.Description = redact(res.Description, secrets)
+
+	res.Assertions = redactAssertions(res.Assertions, secrets)
+
+	if len(res.Steps) > 0 {
+		steps := make([]StepResult, len(res.Steps))
+		for i, step := range res.Steps {
+			step.Description = redact(step.Description, secrets)
+			step.Assertions = redactAssertions(step.Assertions, secrets)
+			steps[i] = step
+		}
+		res.Steps = steps
+	}
+
+	if len(res.Details) > 0 {
+		details := make(map[string]string, len(res.Details))
+		for key, value := range res.Details {
+			details[key] = redact(value, secrets)
+		}
+		res.Details = details
+	}
+	return res
+}
+
+func redactAssertions(results []AssertionResult, secrets []string) []AssertionResult {
+	if len(results) == 0 {
+		return results
+	}
+	out := make([]AssertionResult, len(results))
+	for i, a := range results {
+		a.Expected = redact(a.Expected, secrets)
+		a.Actual = redact(a.Actual, secrets)
+		a.Message = redact(a.Message, secrets)
+		out[i] = a
+	}
+	return out
+}
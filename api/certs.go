@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// -------------------- TLS CERTIFICATES --------------------
+
+const defaultCertExpiryDays = 14
+
+type TLSInfo struct {
+	Version       string            `json:"version,omitempty"`
+	ServerName    string            `json:"server_name,omitempty"`
+	HostnameMatch bool              `json:"hostname_match"`
+	DaysRemaining int               `json:"days_remaining"`
+	Error         string            `json:"error,omitempty"`
+	Chain         []CertificateInfo `json:"chain,omitempty"`
+}
+
+type CertificateInfo struct {
+	Subject       string   `json:"subject"`
+	Issuer        string   `json:"issuer"`
+	SANs          []string `json:"sans,omitempty"`
+	NotBefore     string   `json:"not_before"`
+	NotAfter      string   `json:"not_after"`
+	DaysRemaining int      `json:"days_remaining"`
+}
+
+// probeClient returns a client that dials a fresh connection per probe and
+// records the peer chain into info. Verification is done by hand in
+// VerifyConnection so the chain is captured even when the handshake fails.
+// hostname is only used when no SNI was sent, i.e. for IP address hosts.
+func probeClient(hostname string, info *TLSInfo) *http.Client {
+	tr := httpClient.Transport.(*http.Transport).Clone()
+	tr.DisableKeepAlives = true
+	tr.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if cs.ServerName == "" {
+				cs.ServerName = hostname
+			}
+			return inspectCertificates(cs, info)
+		},
+	}
+	return &http.Client{Timeout: httpClient.Timeout, Transport: tr}
+}
+
+func inspectCertificates(cs tls.ConnectionState, info *TLSInfo) error {
+	*info = TLSInfo{
+		Version:    tls.VersionName(cs.Version),
+		ServerName: cs.ServerName,
+	}
+
+	if len(cs.PeerCertificates) == 0 {
+		info.Error = "server sent no certificates"
+		return errors.New(info.Error)
+	}
+
+	now := time.Now()
+	info.DaysRemaining = math.MaxInt
+	intermediates := x509.NewCertPool()
+
+	for i, cert := range cs.PeerCertificates {
+		days := daysUntil(cert.NotAfter, now)
+		info.Chain = append(info.Chain, CertificateInfo{
+			Subject:       cert.Subject.String(),
+			Issuer:        cert.Issuer.String(),
+			SANs:          cert.DNSNames,
+			NotBefore:     cert.NotBefore.UTC().Format(time.RFC3339),
+			NotAfter:      cert.NotAfter.UTC().Format(time.RFC3339),
+			DaysRemaining: days,
+		})
+		info.DaysRemaining = min(info.DaysRemaining, days)
+		if i > 0 {
+			intermediates.AddCert(cert)
+		}
+	}
+
+	leaf := cs.PeerCertificates[0]
+	info.HostnameMatch = leaf.VerifyHostname(cs.ServerName) == nil
+
+	_, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	})
+	if err != nil {
+		info.Error = err.Error()
+		return err
+	}
+	return nil
+}
+
+func daysUntil(t, now time.Time) int {
+	return int(math.Floor(t.Sub(now).Hours() / 24))
+}
+
+// applyCertExpiry degrades an otherwise healthy result whose certificate
+// chain expires within the monitor's warning window.
+func applyCertExpiry(req HttpRequest, info *TLSInfo, state, description string) (string, string) {
+	if len(info.Chain) == 0 {
+		return state, description
+	}
+
+	window := req.CertExpiry
+	if window <= 0 {
+		window = defaultCertExpiryDays
+	}
+	if info.DaysRemaining > window {
+		return state, description
+	}
+
+	if state == hr.Up {
+		state = hr.Warn
+	}
+	return state, fmt.Sprintf("%s - certificate expires in %d days", description, info.DaysRemaining)
+}
+
+// tlsProbeConfig is the crypto/tls equivalent of probeClient for probes
+// that dial their own connections.
+func tlsProbeConfig(serverName string, info *TLSInfo) *tls.Config {
+	return &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if cs.ServerName == "" {
+				cs.ServerName = serverName
+			}
+			return inspectCertificates(cs, info)
+		},
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// -------------------- STATE CONFIRMATION --------------------
+
+// recheckDelay is how long a worker waits before probing again after a
+// first, unconfirmed failure.
+const recheckDelay = 2 * time.Second
+
+// stateConfirmer holds back a change between down and not down until it has
+// been seen failAfter (or recoverAfter) times in a row, so a single dropped
+// packet neither turns the status page red nor counts as downtime.
+type stateConfirmer struct {
+	failAfter    int
+	recoverAfter int
+	confirmed    string
+	streak       int
+}
+
+func newStateConfirmer(req HttpRequest) *stateConfirmer {
+	return &stateConfirmer{
+		failAfter:    max(req.FailAfter, 1),
+		recoverAfter: max(req.RecoverAfter, 1),
+		confirmed:    hr.Up,
+	}
+}
+
+// confirm returns res with the state that should be reported, and whether
+// res is a first failure worth rechecking right away. Unknown results are
+// passed through and leave the streak alone.
+func (c *stateConfirmer) confirm(res ProbeResult) (ProbeResult, bool) {
+	if len(res.State) == 0 || res.State[0] == hr.Unknown {
+		return res, false
+	}
+
+	state := res.State[0]
+	failing := state == hr.Down
+	if failing == (c.confirmed == hr.Down) {
+		c.confirmed = state
+		c.streak = 0
+		return res, false
+	}
+
+	c.streak++
+	needed := c.recoverAfter
+	if failing {
+		needed = c.failAfter
+	}
+	if c.streak >= needed {
+		c.confirmed = state
+		c.streak = 0
+		return res, false
+	}
+
+	res.State = []string{c.confirmed}
+	if failing {
+		res.Description = fmt.Sprintf("unconfirmed failure %d/%d - %s", c.streak, needed, res.Description)
+	} else {
+		res.Description = fmt.Sprintf("recovering %d/%d - %s", c.streak, needed, res.Description)
+	}
+	return res, failing && c.streak == 1
+}
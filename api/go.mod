@@ -3,17 +3,37 @@ module main
 go 1.25.1
 
 require (
+	github.com/coder/websocket v1.8.14
+	github.com/go-sql-driver/mysql v1.9.3
+	github.com/jackc/pgx/v5 v5.7.6
+	github.com/miekg/dns v1.1.68
 	github.com/nats-io/nats.go v1.48.0
+	github.com/redis/go-redis/v9 v9.9.0
 	go.jetify.com/sse v0.1.0
 	go.jetify.com/typeid/v2 v2.0.0-alpha.3
+	golang.org/x/crypto v0.45.0
+	golang.org/x/net v0.47.0
+	google.golang.org/grpc v1.77.0
+	sigs.k8s.io/yaml v1.6.0
 )
 
 require (
+	filippo.io/edwards25519 v1.1.0 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/gofrs/uuid/v5 v5.3.2 // indirect
 	github.com/inselfcontroll/convex-go v0.0.0-20260224235520-ced3bd4b8129
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
 	github.com/klauspost/compress v1.18.0 // indirect
 	github.com/nats-io/nkeys v0.4.11 // indirect
 	github.com/nats-io/nuid v1.0.1 // indirect
-	golang.org/x/crypto v0.45.0 // indirect
+	go.yaml.in/yaml/v2 v2.4.2 // indirect
+	golang.org/x/mod v0.29.0 // indirect
+	golang.org/x/sync v0.18.0 // indirect
 	golang.org/x/sys v0.38.0 // indirect
+	golang.org/x/text v0.31.0 // indirect
+	golang.org/x/tools v0.38.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20251022142026-3a174f9686a8 // indirect
+	google.golang.org/protobuf v1.36.10 // indirect
 )
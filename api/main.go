@@ -5,6 +5,7 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -12,6 +13,8 @@ import (
 	"maps"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
 	"os"
 	"os/signal"
 	"strings"
@@ -34,6 +37,7 @@ const (
 
 	// HTTP methods
 	MethodGet     = "GET"
+	MethodHead    = "HEAD"
 	MethodPost    = "POST"
 	MethodPut     = "PUT"
 	MethodDelete  = "DELETE"
@@ -74,14 +78,14 @@ var (
 	seed             = os.Getenv("NATS_SEED")
 	serverURL        = os.Getenv("NATS_URL")
 	userAgent        = os.Getenv("USER_AGENT")
-	probeManagerOnce sync.Once
 	monitorStartTime = time.Now().UTC().Truncate(24 * time.Hour)
-	hr               = HealthResponse{Down: "down", Up: "up", Warn: "warn"}
+	hr               = HealthResponse{Down: "down", Up: "up", Warn: "warn", Unknown: "unknown"}
 	nc               *nats.Conn
 	err              error
 	wg               sync.WaitGroup
 	js               jetstream.JetStream
 	kv               jetstream.KeyValue
+	targetsKV        jetstream.KeyValue
 	convexClient     = convex.NewClient(os.Getenv("CONVEX_DB_URL"), nil)
 )
 
@@ -106,82 +110,73 @@ var slaTrackers = struct {
 	m map[string]*SlidingSLA
 }{m: make(map[string]*SlidingSLA)}
 
-func fetchTargets(ctx context.Context) []HttpRequest {
-
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	type Status struct {
-		Name     string `json:"name"`
-		Protocol string `json:"protocol"`
-		Host     string `json:"host"`
-		Interval int64  `json:"interval"`
-	}
-
-	args := map[string]any{
-		"apiKey": os.Getenv("API_KEY"),
-	}
-
-	statuses, err := convex.Query[[]Status](ctx, convexClient, "status:get", args)
-
-	if err != nil {
-		if convexErr, ok := convex.IsConvexError(err); ok {
-			slog.Error("Convex error", "message", convexErr.Message)
-		} else {
-			slog.Error("Request failed", "error", err)
-		}
-	}
-
-	raw := []HttpRequest{}
-	for _, u := range statuses {
-		raw = append(raw, HttpRequest{
-			Name:     u.Name,
-			Protocol: u.Protocol,
-			Host:     u.Host,
-			Interval: time.Duration(u.Interval) * time.Second,
-		})
-	}
-
-	out := make([]HttpRequest, 0, len(raw))
-	counts := make(map[string]int)
-
-	for _, r := range raw {
-		name := r.Name
-		counts[name]++
-		if counts[name] > 1 {
-			r.Name = fmt.Sprintf("%s-%d", name, counts[name])
-
-		}
-		out = append(out, r)
-	}
-	return out
-}
-
 // -------------------- MODELS --------------------
 
 type HttpRequest struct {
-	Host     string        `json:"host,omitempty"`
-	Protocol string        `json:"protocol,omitempty"`
-	Interval time.Duration `json:"interval,omitempty"`
-	Name     string        `json:"name,omitempty"`
-	Username string        `json:"username,omitempty"`
-	Password string        `json:"password,omitempty"`
+	// ID is the monitor's database ID, which keys its state everywhere so
+	// that renaming it keeps its history.
+	ID          string            `json:"id,omitempty"`
+	Host        string            `json:"host,omitempty"`
+	Protocol    string            `json:"protocol,omitempty"`
+	Interval    time.Duration     `json:"interval,omitempty"`
+	Name        string            `json:"name,omitempty"`
+	Paused      bool              `json:"paused,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	Password    string            `json:"-"`
+	Assertions  []Assertion       `json:"assertions,omitempty"`
+	Method      string            `json:"method,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        string            `json:"body,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	UserAgent   string            `json:"userAgent,omitempty"`
+	AuthType    string            `json:"authType,omitempty"`
+	Token       string            `json:"-"`
+	CertExpiry  int               `json:"certExpiryDays,omitempty"`
+
+	WarnLatency    time.Duration `json:"warnLatency,omitempty"`
+	DownLatency    time.Duration `json:"downLatency,omitempty"`
+	DegradedWeight float64       `json:"degradedWeight,omitempty"`
+
+	// FailAfter and RecoverAfter are how many results in a row it takes to
+	// mark the monitor down, and up again.
+	FailAfter    int `json:"failAfter,omitempty"`
+	RecoverAfter int `json:"recoverAfter,omitempty"`
+
+	// Config holds the protocol specific settings, decoded by the
+	// protocol's Prober into Options.
+	Config  json.RawMessage `json:"config,omitempty"`
+	Options any             `json:"-"`
+
+	// credentialsMissing marks a monitor restored from a snapshot without
+	// its credentials; it reports unknown rather than failing to log in.
+	credentialsMissing bool
 }
 
 type HealthResponse struct {
-	Down string `json:"down"`
-	Up   string `json:"up"`
-	Warn string `json:"warn"`
+	Down    string `json:"down"`
+	Up      string `json:"up"`
+	Warn    string `json:"warn"`
+	Unknown string `json:"unknown"`
 }
 
 type ProbeResult struct {
-	Id          string   `json:"id,omitempty"`
-	Name        string   `json:"name,omitempty"`
-	Protocol    string   `json:"protocol,omitempty"`
-	State       []string `json:"state,omitempty"`
-	Description string   `json:"description,omitempty"`
-	Date        []string `json:"date,omitempty"`
-	Timestamp   string   `json:"timestamp,omitempty"`
+	Id          string             `json:"id,omitempty"`
+	Name        string             `json:"name,omitempty"`
+	Protocol    string             `json:"protocol,omitempty"`
+	State       []string           `json:"state,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Date        []string           `json:"date,omitempty"`
+	Timestamp   string             `json:"timestamp,omitempty"`
+	Assertions  []AssertionResult  `json:"assertions,omitempty"`
+	TLS         *TLSInfo           `json:"tls,omitempty"`
+	DNS         *DNSResult         `json:"dns,omitempty"`
+	Metrics     map[string]float64 `json:"metrics,omitempty"`
+	Details     map[string]string  `json:"details,omitempty"`
+	Steps       []StepResult       `json:"steps,omitempty"`
+
+	ResponseTime    float64          `json:"response_time,omitempty"`
+	Timings         *Timings         `json:"timings,omitempty"`
+	ResponseHistory []ResponseSample `json:"response_history,omitempty"`
 }
 
 type ProbeResponse struct {
@@ -199,15 +194,18 @@ type ErrorResponse struct {
 	Message string   `json:"message"`
 }
 
-type bucket struct{ totalSec, downSec int64 }
+type bucket struct{ totalSec, downSec, degradedSec int64 }
 
 type SlidingSLA struct {
-	Target        float64
-	buckets       []bucket
-	idx           int
-	currentMinute time.Time
-	lastUpdate    time.Time
-	mu            sync.Mutex
+	Target float64
+	// DegradedWeight is the fraction of degraded ("warn") time that counts
+	// against availability: 0 treats it as up, 1 as down.
+	DegradedWeight float64
+	buckets        []bucket
+	idx            int
+	currentMinute  time.Time
+	lastUpdate     time.Time
+	mu             sync.Mutex
 }
 
 // -------------------- RECOVERY --------------------
@@ -321,153 +319,149 @@ func slaId() string {
 
 // -------------------- PROBES --------------------
 
-func probeHTTP(re HttpRequest) ProbeResult {
-
-	url := fmt.Sprintf("%s://%s", re.Protocol, re.Host)
+type httpProber struct{}
 
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
-	defer cancel()
+func (httpProber) Prepare(req *HttpRequest) error {
+	switch req.Method {
+	case "", MethodGet, MethodHead, MethodPost, MethodPut, MethodPatch, MethodDelete, MethodOptions:
+	default:
+		return fmt.Errorf("unsupported HTTP method %q", req.Method)
+	}
 
-	r, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		slog.Error("Failed to create HTTP request", "error", err)
+	switch req.authType() {
+	case AuthBasic, AuthDigest:
+		if req.Username == "" {
+			return fmt.Errorf("%s auth requires a username", req.authType())
+		}
+	case AuthBearer:
+		if req.Token == "" {
+			return errors.New("bearer auth requires a token")
+		}
+	case "":
+		if req.AuthType != "" {
+			return fmt.Errorf("unsupported auth type %q", req.AuthType)
+		}
 	}
 
-	if userAgent == "" {
-		userAgent = "OddinStatus/1.0"
+	if _, err := url.Parse(fmt.Sprintf("%s://%s", normalizeProtocol(req.Protocol), req.Host)); err != nil {
+		return err
 	}
 
-	r.Header.Set("User-Agent", userAgent)
+	return validateAssertions(req.Assertions)
+}
 
-	resp, err := httpClient.Do(r)
-	if err != nil {
-		return ProbeResult{
-			Id:          "",
-			Name:        re.Name,
-			Protocol:    strings.ToUpper(re.Protocol),
-			Description: fmt.Sprintf("%s - %s", re.Host, err.Error()),
-			Timestamp:   time.Now().Format("15:04:05.000"),
-			Date:        getRecentDates(),
-			State:       []string{hr.Down},
-		}
-	}
-	defer resp.Body.Close()
+func (httpProber) Probe(ctx context.Context, req HttpRequest) ProbeResult {
+	return probeHTTP(ctx, req)
+}
 
-	if resp.StatusCode < StatusOK || resp.StatusCode >= StatusBadRequest {
-		return ProbeResult{
-			Id:          "",
-			Name:        re.Name,
-			Protocol:    strings.ToUpper(re.Protocol),
-			Description: fmt.Sprintf("%s - %d", re.Host, resp.StatusCode),
-			Timestamp:   time.Now().Format("15:04:05.000"),
-			Date:        getRecentDates(),
-			State:       []string{hr.Down},
-		}
-	}
-	return ProbeResult{
-		Id:          "",
-		Name:        re.Name,
-		Protocol:    strings.ToUpper(re.Protocol),
-		Description: fmt.Sprintf("%s - %d", re.Host, resp.StatusCode),
-		Timestamp:   time.Now().Format("15:04:05.000"),
-		Date:        getRecentDates(),
-		State:       []string{hr.Up},
-	}
+func init() {
+	RegisterProber(httpProber{}, "http", "https")
 }
 
-func probeTCP(req HttpRequest) ProbeResult {
-	conn, err := net.DialTimeout("tcp", req.Host, defaultTimeout)
+func probeHTTP(ctx context.Context, re HttpRequest) ProbeResult {
 
-	if err != nil {
-		return ProbeResult{
-			Id:          "",
-			Name:        req.Name,
-			Protocol:    strings.ToUpper(req.Protocol),
-			Description: err.Error(),
-			Timestamp:   time.Now().Format("15:04:05.000"),
-			Date:        getRecentDates(),
-			State:       []string{hr.Down},
-		}
+	url := fmt.Sprintf("%s://%s", normalizeProtocol(re.Protocol), re.Host)
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	timer := newPhaseTimer()
+	ctx = httptrace.WithClientTrace(ctx, timer.trace())
+
+	method := re.Method
+	if method == "" {
+		method = MethodGet
+	}
+
+	var reqBody io.Reader
+	if re.Body != "" {
+		reqBody = strings.NewReader(re.Body)
 	}
-	defer conn.Close()
 
-	_, err = conn.Write([]byte("ping\n"))
+	r, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
-		return ProbeResult{
-			Id:          "",
-			Name:        req.Name,
-			Protocol:    strings.ToUpper(req.Protocol),
-			Description: "write failed: " + err.Error(),
-			Timestamp:   time.Now().Format("15:04:05.000"),
-			Date:        getRecentDates(),
-			State:       []string{hr.Down},
-		}
+		slog.Error("Failed to create HTTP request", "error", err)
+		return newProbeResult(re, hr.Down, fmt.Sprintf("%s - %s", re.Host, err.Error()))
 	}
 
-	buf := make([]byte, 64)
-	_ = conn.SetReadDeadline(time.Now().Add(1 * time.Second))
-	n, err := conn.Read(buf)
-	if err != nil || n == 0 {
-		return ProbeResult{
-			Id:          "",
-			Name:        req.Name,
-			Protocol:    strings.ToUpper(req.Protocol),
-			Description: "no response after connect",
-			Timestamp:   time.Now().Format("15:04:05.000"),
-			Date:        getRecentDates(),
-			State:       []string{hr.Up},
+	for key, value := range re.Headers {
+		if strings.EqualFold(key, "Host") {
+			r.Host = value
+			continue
 		}
+		r.Header.Set(key, value)
 	}
 
-	return ProbeResult{
-		Id:          "",
-		Name:        req.Name,
-		Protocol:    strings.ToUpper(req.Protocol),
-		Description: fmt.Sprintf("response received %s", strings.TrimSpace(string(buf[:n]))),
-		Timestamp:   time.Now().Format("15:04:05.000"),
-		Date:        getRecentDates(),
-		State:       []string{hr.Up},
+	if re.ContentType != "" {
+		r.Header.Set(HeaderContentType, re.ContentType)
+	}
+
+	if userAgent == "" {
+		userAgent = "OddinStatus/1.0"
 	}
-}
 
-func probeDNS(req HttpRequest) ProbeResult {
+	switch {
+	case re.UserAgent != "":
+		r.Header.Set("User-Agent", re.UserAgent)
+	case r.Header.Get("User-Agent") == "":
+		r.Header.Set("User-Agent", userAgent)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
-	defer cancel()
+	var tlsInfo TLSInfo
 
-	if net.ParseIP(req.Host) != nil {
-		return ProbeResult{
-			Id:          "",
-			Name:        req.Name,
-			Protocol:    strings.ToUpper(req.Protocol),
-			Description: "Input is already an IP, DNS lookup skipped",
-			Timestamp:   time.Now().Format("15:04:05.000"),
-			Date:        getRecentDates(),
-			State:       []string{hr.Warn},
+	resp, err := doWithAuth(probeClient(r.URL.Hostname(), &tlsInfo), r, re)
+	if err != nil {
+		res := newProbeResult(re, hr.Down, fmt.Sprintf("%s - %s", re.Host, err.Error()))
+		res.Timings = timer.timings(time.Now())
+		res.ResponseTime = res.Timings.Total
+		if len(tlsInfo.Chain) > 0 {
+			res.TLS = &tlsInfo
 		}
+		return res
 	}
+	defer resp.Body.Close()
 
-	addrs, err := net.DefaultResolver.LookupHost(ctx, req.Host)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	timings := timer.timings(time.Now())
 	if err != nil {
-		return ProbeResult{
-			Id:          "",
-			Name:        req.Name,
-			Protocol:    strings.ToUpper(req.Protocol),
-			Description: fmt.Sprintf("DNS error: %s", err.Error()),
-			Timestamp:   time.Now().Format("15:04:05.000"),
-			Date:        getRecentDates(),
-			State:       []string{hr.Down},
+		res := newProbeResult(re, hr.Down, fmt.Sprintf("%s - %d - read body failed: %s", re.Host, resp.StatusCode, err.Error()))
+		res.Timings = timings
+		res.ResponseTime = timings.Total
+		if len(tlsInfo.Chain) > 0 {
+			res.TLS = &tlsInfo
 		}
+		return res
+	}
+
+	results := evaluateAssertions(re.Assertions, resp, body)
+	state, failures := assertionState(results)
+
+	description := fmt.Sprintf("%s - %d", re.Host, resp.StatusCode)
+	if len(failures) > 0 {
+		description = fmt.Sprintf("%s - %s", description, strings.Join(failures, "; "))
+	}
+
+	state, description = applyCertExpiry(re, &tlsInfo, state, description)
+
+	res := newProbeResult(re, state, description)
+	res.Assertions = results
+	res.Timings = timings
+	res.ResponseTime = timings.Total
+	if len(tlsInfo.Chain) > 0 {
+		res.TLS = &tlsInfo
 	}
+	return res
+}
 
+func newProbeResult(req HttpRequest, state, description string) ProbeResult {
 	return ProbeResult{
 		Id:          "",
 		Name:        req.Name,
 		Protocol:    strings.ToUpper(req.Protocol),
-		Description: fmt.Sprintf("resolved %v", addrs),
+		Description: description,
 		Timestamp:   time.Now().Format("15:04:05.000"),
 		Date:        getRecentDates(),
-		State:       []string{hr.Up, "up"},
+		State:       []string{state},
 	}
 }
 
@@ -483,11 +477,12 @@ func NewSlidingSLA(target float64) *SlidingSLA {
 	}
 }
 
-func (s *SlidingSLA) SetState(totalSec, downSec int64) {
+func (s *SlidingSLA) SetState(totalSec, downSec, degradedSec int64) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.buckets[s.idx].totalSec = totalSec
 	s.buckets[s.idx].downSec = downSec
+	s.buckets[s.idx].degradedSec = degradedSec
 }
 
 func (s *SlidingSLA) rotateTo(now time.Time) {
@@ -514,18 +509,28 @@ func (s *SlidingSLA) rotateTo(now time.Time) {
 	s.currentMinute = minNow
 }
 
-func (s *SlidingSLA) Tick(isDown bool, interval time.Duration) {
+func (s *SlidingSLA) Tick(state string, interval time.Duration) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	now := time.Now()
 	s.rotateTo(now)
 
+	// An unknown result says nothing about the service, so it is left out
+	// of the window instead of counting as up or down.
+	if state == hr.Unknown {
+		s.lastUpdate = now
+		return
+	}
+
 	inc := int64(interval.Round(time.Second).Seconds())
 
 	s.buckets[s.idx].totalSec += inc
-	if isDown {
+	switch state {
+	case hr.Down:
 		s.buckets[s.idx].downSec += inc
+	case hr.Warn:
+		s.buckets[s.idx].degradedSec += inc
 	}
 	s.lastUpdate = now
 }
@@ -534,25 +539,27 @@ func (s *SlidingSLA) Snapshot() map[string]any {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	var total, down int64
+	var total, down, degraded int64
 	for _, b := range s.buckets {
 		total += b.totalSec
 		down += b.downSec
+		degraded += b.degradedSec
 	}
 
 	if total <= 0 {
 		return map[string]any{
-			"id":                 "",
-			"sla_target":         "99.999%",
-			"uptime90":           "99.999%",
-			"up_time_seconds":    formatDurationFull(0),
-			"down_time_seconds":  formatDurationFull(0),
-			"total_time_seconds": formatDurationFull(0),
-			"sla_breached":       false,
+			"id":                    "",
+			"sla_target":            "99.999%",
+			"uptime90":              "99.999%",
+			"up_time_seconds":       formatDurationFull(0),
+			"down_time_seconds":     formatDurationFull(0),
+			"degraded_time_seconds": formatDurationFull(0),
+			"total_time_seconds":    formatDurationFull(0),
+			"sla_breached":          false,
 		}
 	}
 
-	availability := 1.0 - (float64(down) / float64(total))
+	availability := weightedAvailability(total, down, degraded, s.DegradedWeight)
 	percent := availability * 100
 
 	uptimeStr := fmt.Sprintf("%.3f%%", percent)
@@ -560,20 +567,28 @@ func (s *SlidingSLA) Snapshot() map[string]any {
 		uptimeStr = "99.999%"
 	}
 
-	breached := (s.Target >= 1.0 && down > 0) || (availability < s.Target)
-	up := total - down
+	breached := (s.Target >= 1.0 && availability < 1.0) || (availability < s.Target)
+	up := total - down - degraded
 
 	return map[string]any{
-		"id":                 "",
-		"sla_target":         "99.999%",
-		"uptime90":           uptimeStr,
-		"up_time_seconds":    formatDurationFull(up),
-		"down_time_seconds":  formatDurationFull(down),
-		"total_time_seconds": formatDurationFull(total),
-		"sla_breached":       breached,
+		"id":                    "",
+		"sla_target":            "99.999%",
+		"uptime90":              uptimeStr,
+		"up_time_seconds":       formatDurationFull(up),
+		"down_time_seconds":     formatDurationFull(down),
+		"degraded_time_seconds": formatDurationFull(degraded),
+		"total_time_seconds":    formatDurationFull(total),
+		"sla_breached":          breached,
 	}
 }
 
+func weightedAvailability(total, down, degraded int64, weight float64) float64 {
+	if total <= 0 {
+		return 1.0
+	}
+	return 1.0 - ((float64(down) + float64(degraded)*weight) / float64(total))
+}
+
 func (s *SlidingSLA) Reset() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -585,110 +600,6 @@ func (s *SlidingSLA) Reset() {
 	s.lastUpdate = time.Now()
 }
 
-func startProbeManager(ctx context.Context, wg *sync.WaitGroup) {
-	probeManagerOnce.Do(func() {
-		slog.Info("Starting probe manager...")
-
-		for _, target := range fetchTargets(ctx) {
-
-			t := target
-
-			var probeFn func(HttpRequest) ProbeResult
-			switch strings.ToLower(strings.TrimSpace(t.Protocol)) {
-			case "tcp":
-				probeFn = probeTCP
-			case "http", "https":
-				probeFn = probeHTTP
-			case "dns":
-				probeFn = probeDNS
-			}
-
-			if probeFn == nil {
-				slog.Warn("Unsupported protocol", "protocol", t.Protocol)
-				continue
-			}
-
-			wg.Add(1)
-
-			interval := t.Interval
-			if interval <= 0 {
-				interval = 1 * time.Second
-			}
-
-			go func(req HttpRequest, fn func(HttpRequest) ProbeResult, iv time.Duration) {
-				defer wg.Done()
-
-				slaTrackers.Lock()
-				tracker := NewSlidingSLA(0.99999)
-				slaTrackers.m[req.Name] = tracker
-
-				existingData := readFromNATS(req.Name)
-				if existingData != nil {
-					var wrapped map[string]any
-					if err := json.Unmarshal(existingData, &wrapped); err == nil {
-						if payload, ok := wrapped["payload"].(map[string]any); ok {
-							if sla, ok := payload["sla"].(map[string]any); ok {
-								if history, ok := sla["history"].([]any); ok && len(history) > 0 {
-									first := history[0].(map[string]any)
-
-									tSec := parseDurationToSecs(first["total_time_seconds"].(string))
-									dSec := parseDurationToSecs(first["down_time_seconds"].(string))
-
-									tracker.SetState(tSec, dSec)
-									slog.Info("Hydrated existing state", "name", req.Name, "uptime", first["uptime90"])
-								}
-							}
-						}
-					}
-				}
-				slaTrackers.Unlock()
-
-				ticker := time.NewTicker(iv)
-				defer ticker.Stop()
-
-				for {
-					select {
-					case <-ctx.Done():
-						slog.Info("Stopping probe worker", "name", req.Name)
-						return
-
-					case <-ticker.C:
-						ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
-
-						res := fn(req)
-
-						slaTrackers.Lock()
-						tracker := slaTrackers.m[req.Name]
-						if tracker == nil {
-							tracker = NewSlidingSLA(0.99999)
-							slaTrackers.m[req.Name] = tracker
-						}
-						slaTrackers.Unlock()
-
-						isDown := len(res.State) > 0 && strings.ToLower(res.State[0]) == hr.Down
-						tracker.Tick(isDown, interval)
-
-						payload := StatusPayload{
-							Probe: res,
-							SLA:   tracker.Snapshot(),
-						}
-
-						publishToNATS(ctx, req.Name, &payload, tracker)
-
-						// Broadcast update
-						globalHub.Broadcast(map[string]StatusPayload{req.Name: payload})
-
-						cancel()
-
-					}
-				}
-
-			}(t, probeFn, interval)
-		}
-	})
-
-}
-
 // -------------------- SSE HANDLER --------------------
 
 func Sse(w http.ResponseWriter, r *http.Request) {
@@ -718,6 +629,7 @@ func Sse(w http.ResponseWriter, r *http.Request) {
 
 	globalHub.Unlock()
 
+	version := targets.Version()
 	if len(initialData) > 0 {
 		sendUpdateToConn(ctx, conn, initialData)
 	}
@@ -734,29 +646,17 @@ func Sse(w http.ResponseWriter, r *http.Request) {
 			return
 		case update := <-clientChan:
 
-			reqLookup := make(map[string]int, len(fetchTargets(ctx)))
-			for i, r := range fetchTargets(ctx) {
-				reqLookup[r.Name] = i
+			// Monitors were added, removed or reordered since the last
+			// message, so resend them all with their new indexes.
+			if v := targets.Version(); v != version {
+				version = v
+				globalHub.RLock()
+				update = maps.Clone(globalHub.cache)
+				globalHub.RUnlock()
 			}
 
-			for name, payload := range update {
-
-				idx, found := reqLookup[name]
-				if !found {
-					continue
-				}
-
-				out := map[string]any{
-					"index": idx,
-					"payload": map[string]any{
-						"probe": payload.Probe,
-						"sla":   payload.SLA,
-					},
-				}
-
-				if err := conn.SendData(ctx, out); err != nil {
-					return
-				}
+			if err := sendUpdateToConn(ctx, conn, update); err != nil {
+				return
 			}
 		}
 	}
@@ -764,14 +664,9 @@ func Sse(w http.ResponseWriter, r *http.Request) {
 
 func sendUpdateToConn(ctx context.Context, conn *sse.Conn, update map[string]StatusPayload) error {
 
-	reqLookup := make(map[string]int, len(fetchTargets(ctx)))
-	for i, r := range fetchTargets(ctx) {
-		reqLookup[r.Name] = i
-	}
-
-	for name, payload := range update {
+	for id, payload := range update {
 
-		idx, found := reqLookup[name]
+		idx, found := targets.Index(id)
 		if !found {
 			continue
 		}
@@ -796,23 +691,24 @@ func StatusHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set(HeaderContentType, ContentTypeJSON)
 
-	var hasMonitors bool
-	var miniMonitors bool
-	if len(fetchTargets(context.Background())) == 0 {
-		hasMonitors = false
-	} else {
-		hasMonitors = true
+	count := targets.Len()
+
+	response := map[string]any{
+		"monitors":     count > 0,
+		"miniMonitors": count > 3,
+		"version":      targets.Version(),
+		"stale":        false,
 	}
 
-	if len(fetchTargets(context.Background())) > 3 {
-		miniMonitors = true
-	} else {
-		miniMonitors = false
+	// The monitors could not be reloaded lately, so what is shown may be out
+	// of date.
+	if since, stale := targets.Stale(); stale {
+		response["stale"] = true
+		response["staleSince"] = since.UTC().Format(time.RFC3339)
 	}
 
-	response := map[string]bool{
-		"monitors":     hasMonitors,
-		"miniMonitors": miniMonitors,
+	if invalid := getTargetErrors(); len(invalid) > 0 {
+		response["errors"] = invalid
 	}
 
 	respJSON, err := json.MarshalIndent(response, "", "  ")
@@ -836,12 +732,12 @@ func ResetHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set(HeaderContentType, ContentTypeJSON)
 
-	name := r.URL.Query().Get("name")
+	id := r.URL.Query().Get("id")
 	empty := r.URL.Query().Get("empty") == "true"
 
 	slaTrackers.Lock()
-	if name != "" {
-		if tracker, ok := slaTrackers.m[name]; ok {
+	if id != "" {
+		if tracker, ok := slaTrackers.m[id]; ok {
 			tracker.Reset()
 		}
 	} else {
@@ -858,7 +754,7 @@ func ResetHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(StatusOK)
 	_ = json.NewEncoder(w).Encode(map[string]any{
 		"sla_reset": true,
-		"probe":     name,
+		"probe":     id,
 	})
 }
 
@@ -869,7 +765,7 @@ func CreatePage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func publishToNATS(ctx context.Context, name string, payload *StatusPayload, s *SlidingSLA) {
+func publishToNATS(ctx context.Context, id string, payload *StatusPayload, s *SlidingSLA) {
 	if nc.Status() != nats.CONNECTED {
 		slog.Error("NATS not connected")
 		return
@@ -890,7 +786,7 @@ func publishToNATS(ctx context.Context, name string, payload *StatusPayload, s *
 	}
 
 	for range 3 {
-		entry, getErr := kv.Get(ctx, name)
+		entry, getErr := kv.Get(ctx, id)
 		var revision uint64 = 0
 		var oldPayload StatusPayload
 
@@ -940,26 +836,29 @@ func publishToNATS(ctx context.Context, name string, payload *StatusPayload, s *
 				}
 
 				if h, ok := payload.SLA["history"].([]any); ok && len(h) > 0 {
-					h[0] = map[string]any{
-						"sla_breached":       payload.SLA["sla_breached"],
-						"sla_target":         fmt.Sprintf("%.3f%%", s.Target*100),
-						"total_time_seconds": payload.SLA["total_time_seconds"],
-						"up_time_seconds":    payload.SLA["up_time_seconds"],
-						"down_time_seconds":  payload.SLA["down_time_seconds"],
-						"uptime90":           payload.SLA["uptime90"],
-					}
+					prev, _ := h[0].(map[string]any)
+					h[0] = withResponseTime(map[string]any{
+						"sla_breached":          payload.SLA["sla_breached"],
+						"sla_target":            fmt.Sprintf("%.3f%%", s.Target*100),
+						"total_time_seconds":    payload.SLA["total_time_seconds"],
+						"up_time_seconds":       payload.SLA["up_time_seconds"],
+						"down_time_seconds":     payload.SLA["down_time_seconds"],
+						"degraded_time_seconds": payload.SLA["degraded_time_seconds"],
+						"uptime90":              payload.SLA["uptime90"],
+					}, prev, payload.Probe.ResponseTime)
 				}
 			} else {
 				s.Reset()
 				freshSLA := s.Snapshot()
-				newSnapshot := map[string]any{
-					"sla_breached":       freshSLA["sla_breached"],
-					"sla_target":         fmt.Sprintf("%.3f%%", s.Target*100),
-					"total_time_seconds": freshSLA["total_time_seconds"],
-					"up_time_seconds":    freshSLA["up_time_seconds"],
-					"down_time_seconds":  freshSLA["down_time_seconds"],
-					"uptime90":           freshSLA["uptime90"],
-				}
+				newSnapshot := withResponseTime(map[string]any{
+					"sla_breached":          freshSLA["sla_breached"],
+					"sla_target":            fmt.Sprintf("%.3f%%", s.Target*100),
+					"total_time_seconds":    freshSLA["total_time_seconds"],
+					"up_time_seconds":       freshSLA["up_time_seconds"],
+					"down_time_seconds":     freshSLA["down_time_seconds"],
+					"degraded_time_seconds": freshSLA["degraded_time_seconds"],
+					"uptime90":              freshSLA["uptime90"],
+				}, nil, payload.Probe.ResponseTime)
 				if oldHist, ok := oldPayload.SLA["history"].([]any); ok {
 					payload.SLA["history"] = append([]any{newSnapshot}, oldHist...)
 				}
@@ -967,50 +866,51 @@ func publishToNATS(ctx context.Context, name string, payload *StatusPayload, s *
 				payload.Probe.State = append([]string{currentStatus}, oldPayload.Probe.State...)
 			}
 		} else {
-			payload.SLA["history"] = []any{map[string]any{
-				"sla_breached":       payload.SLA["sla_breached"],
-				"sla_target":         fmt.Sprintf("%.3f%%", s.Target*100),
-				"total_time_seconds": payload.SLA["total_time_seconds"],
-				"up_time_seconds":    payload.SLA["up_time_seconds"],
-				"down_time_seconds":  payload.SLA["down_time_seconds"],
-				"uptime90":           payload.SLA["uptime90"],
-			}}
+			payload.SLA["history"] = []any{withResponseTime(map[string]any{
+				"sla_breached":          payload.SLA["sla_breached"],
+				"sla_target":            fmt.Sprintf("%.3f%%", s.Target*100),
+				"total_time_seconds":    payload.SLA["total_time_seconds"],
+				"up_time_seconds":       payload.SLA["up_time_seconds"],
+				"down_time_seconds":     payload.SLA["down_time_seconds"],
+				"degraded_time_seconds": payload.SLA["degraded_time_seconds"],
+				"uptime90":              payload.SLA["uptime90"],
+			}, nil, payload.Probe.ResponseTime)}
 			payload.Probe.Date = []string{todayUTC}
 		}
 
+		sample := ResponseSample{Timestamp: now.Format(time.RFC3339), ResponseTime: payload.Probe.ResponseTime}
+		payload.Probe.ResponseHistory = capSlice(append([]ResponseSample{sample}, oldPayload.Probe.ResponseHistory...), responseHistorySize)
+
 		payload.Probe.State = capSlice(payload.Probe.State, 90)
 		payload.Probe.Date = capSlice(payload.Probe.Date, 90)
 		if h, ok := payload.SLA["history"].([]any); ok {
 			payload.SLA["history"] = capSlice(h, 90)
 		}
 
-		var rootTotal, rootDown int64
+		var rootTotal, rootDown, rootDegraded int64
 		if h, ok := payload.SLA["history"].([]any); ok {
 			for _, hEntry := range h {
 				if m, ok := hEntry.(map[string]any); ok {
 					rootTotal += parseDurationToSecs(m["total_time_seconds"].(string))
 					rootDown += parseDurationToSecs(m["down_time_seconds"].(string))
+					degraded, _ := m["degraded_time_seconds"].(string)
+					rootDegraded += parseDurationToSecs(degraded)
 				}
 			}
 		}
 
-		rootUp := rootTotal - rootDown
-		rootAvail := 1.0
-		if rootTotal > 0 {
-			rootAvail = 1.0 - (float64(rootDown) / float64(rootTotal))
-		}
+		rootUp := rootTotal - rootDown - rootDegraded
+		rootAvail := weightedAvailability(rootTotal, rootDown, rootDegraded, s.DegradedWeight)
 		payload.SLA["total_time_seconds"] = formatDurationFull(rootTotal)
 		payload.SLA["down_time_seconds"] = formatDurationFull(rootDown)
+		payload.SLA["degraded_time_seconds"] = formatDurationFull(rootDegraded)
 		payload.SLA["up_time_seconds"] = formatDurationFull(rootUp)
 		payload.SLA["uptime90"] = fmt.Sprintf("%.3f%%", rootAvail*100)
-		payload.SLA["sla_breached"] = (s.Target >= 1.0 && rootDown > 0) || (rootAvail < s.Target)
+		payload.SLA["sla_breached"] = (s.Target >= 1.0 && rootAvail < 1.0) || (rootAvail < s.Target)
 
-		idx := -1
-		for i, r := range fetchTargets(ctx) {
-			if r.Name == name {
-				idx = i
-				break
-			}
+		idx, found := targets.Index(id)
+		if !found {
+			idx = -1
 		}
 
 		wrappedPayload := map[string]any{
@@ -1029,9 +929,9 @@ func publishToNATS(ctx context.Context, name string, payload *StatusPayload, s *
 
 		var updateErr error
 		if revision > 0 {
-			_, updateErr = kv.Update(ctx, name, buf.Bytes(), revision)
+			_, updateErr = kv.Update(ctx, id, buf.Bytes(), revision)
 		} else {
-			_, updateErr = kv.Create(ctx, name, buf.Bytes())
+			_, updateErr = kv.Create(ctx, id, buf.Bytes())
 		}
 
 		if updateErr == nil {
@@ -1048,7 +948,7 @@ func capSlice[T any](s []T, max int) []T {
 	return s
 }
 
-func readFromNATS(name string) []byte {
+func readFromNATS(id string) []byte {
 
 	if nc.Status() != nats.CONNECTED {
 		slog.Error("NATS not connected")
@@ -1064,9 +964,9 @@ func readFromNATS(name string) []byte {
 		return nil
 	}
 
-	entry, err := kv.Get(ctx, name)
+	entry, err := kv.Get(ctx, id)
 	if err != nil {
-		slog.Error("Failed to get entry", "key", name, "error", err)
+		slog.Error("Failed to get entry", "key", id, "error", err)
 		return nil
 	}
 
@@ -1100,11 +1000,93 @@ func readFromNATS(name string) []byte {
 
 }
 
+// cleanStatusKeys moves the status of monitors still stored under their
+// name, as before monitors were keyed by ID, and purges the keys of monitors
+// that are gone. It reports whether the bucket could be read.
+func cleanStatusKeys(list []HttpRequest) bool {
+	if nc.Status() != nats.CONNECTED {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	lister, err := kv.ListKeys(ctx)
+	if err != nil {
+		slog.Error("Failed to list status keys", "error", err)
+		return false
+	}
+	keys := make(map[string]bool)
+	for key := range lister.Keys() {
+		keys[key] = true
+	}
+
+	owned := make(map[string]bool, len(list))
+	for id, legacy := range legacyStatusKeys(list) {
+		owned[id] = true
+		if keys[id] || !keys[legacy] {
+			continue
+		}
+
+		entry, err := kv.Get(ctx, legacy)
+		if err == nil {
+			_, err = kv.Create(ctx, id, entry.Value())
+		}
+		if err != nil {
+			slog.Error("Failed to move status to monitor ID", "key", legacy, "id", id, "error", err)
+			owned[legacy] = true
+			continue
+		}
+		slog.Info("Moved status to monitor ID", "key", legacy, "id", id)
+	}
+
+	for key := range keys {
+		if owned[key] {
+			continue
+		}
+		if err := kv.Purge(ctx, key); err != nil {
+			slog.Error("Failed to purge status", "key", key, "error", err)
+			continue
+		}
+		slog.Info("Purged status of deleted monitor", "key", key)
+	}
+	return true
+}
+
+// legacyStatusKeys maps monitor IDs to the key their status used to be
+// stored under: the name, with duplicates numbered in row order.
+func legacyStatusKeys(list []HttpRequest) map[string]string {
+	out := make(map[string]string, len(list))
+	counts := make(map[string]int)
+	for _, t := range list {
+		counts[t.Name]++
+		key := t.Name
+		if counts[t.Name] > 1 {
+			key = fmt.Sprintf("%s-%d", t.Name, counts[t.Name])
+		}
+		out[t.ID] = key
+	}
+	return out
+}
+
+func deleteStatusKey(id string) {
+	if nc.Status() != nats.CONNECTED {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := kv.Purge(ctx, id); err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) {
+		slog.Error("Failed to purge status", "key", id, "error", err)
+	}
+}
+
 func HistoryHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set(HeaderContentType, ContentTypeJSON)
 
-	name := r.URL.Query().Get("name")
-	history := readFromNATS(name)
+	id := r.URL.Query().Get("id")
+	history := readFromNATS(id)
 
 	if history == nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -1122,6 +1104,12 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	source, err := targetSourceFromEnv()
+	if err != nil {
+		slog.Error("Invalid monitor source", "error", err)
+		os.Exit(1)
+	}
+
 	nc, err = nats.Connect(
 		serverURL,
 		nats.UserJWTAndSeed(jwt, seed),
@@ -1162,11 +1150,21 @@ func main() {
 		})
 	}
 
-	startProbeManager(ctx, &wg)
+	targetsKV, err = js.KeyValue(context.Background(), targetsBucket)
+	if err != nil {
+		targetsKV, _ = js.CreateKeyValue(context.Background(), jetstream.KeyValueConfig{
+			Bucket:   targetsBucket,
+			MaxBytes: 1024 * 1024 * 10,
+		})
+	}
+
+	startProbeManager(ctx, &wg, source)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("POST /v1/sse", Sse)
 	mux.HandleFunc("GET /v1/status", StatusHandler)
+	mux.HandleFunc("POST /v1/heartbeat/{token}", HeartbeatHandler)
+	mux.HandleFunc("POST /v1/heartbeat/{token}/fail", HeartbeatHandler)
 	// mux.HandleFunc("GET /v1/status/history", HistoryHandler)
 	// mux.HandleFunc("GET /ping", func(w http.ResponseWriter, r *http.Request) {
 	// 	w.WriteHeader(http.StatusOK)
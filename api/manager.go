@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -------------------- PROBE MANAGER --------------------
+
+// The probe manager keeps one worker per monitor in line with its target
+// source. Every reconcileInterval, or as soon as the source reports a change,
+// it loads the monitors again and starts, stops or restarts the workers whose
+// definition changed. The SLA tracker of a monitor outlives its worker, so an
+// edit or a pause keeps its history. A removed monitor's state is only
+// dropped once it has been missing from forgetAfter fresh, non-empty lists
+// in a row, so a stale or truncated list cannot wipe the status history.
+
+const (
+	reconcileInterval = 30 * time.Second
+	forgetAfter       = 3
+)
+
+type probeWorker struct {
+	// def is the monitor as fetched, before Prepare rewrote any of it.
+	def    HttpRequest
+	req    HttpRequest
+	prober Prober
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type probeManager struct {
+	ctx     context.Context
+	source  TargetSource
+	wg      *sync.WaitGroup
+	workers map[string]*probeWorker
+	errors  map[string]string
+	// known maps the ID of every monitor of the last pass, paused ones
+	// included, to its name.
+	known map[string]string
+	// missing counts the passes a known monitor has been missing for.
+	missing map[string]int
+	// cleaned is set once status keys of old names and deleted monitors
+	// have been cleaned up after startup.
+	cleaned bool
+	// invalid lists the monitors that failed Prepare on the last pass and
+	// sourceErr why the source could not be read, if it could not.
+	invalid   []TargetError
+	sourceErr *TargetError
+}
+
+func startProbeManager(ctx context.Context, wg *sync.WaitGroup, source TargetSource) {
+	slog.Info("Starting probe manager...", "source", source.Name())
+
+	m := &probeManager{
+		ctx:     ctx,
+		source:  source,
+		wg:      wg,
+		workers: make(map[string]*probeWorker),
+		errors:  make(map[string]string),
+		known:   make(map[string]string),
+		missing: make(map[string]int),
+	}
+
+	m.refresh()
+
+	var changes <-chan struct{}
+	if n, ok := source.(changeNotifier); ok {
+		changes = n.Changes(ctx)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(reconcileInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-changes:
+				slog.Info("Monitor source changed, reloading", "source", source.Name())
+			}
+			m.refresh()
+		}
+	}()
+}
+
+// refresh loads the monitors and reconciles them. A source that is down
+// offers its last known good list, which is reconciled along with the live
+// monitors of the other sources. A source that fails otherwise (a broken
+// monitors file, say) says nothing about the monitors, so the ones we have
+// keep being probed and the error is reported with the invalid monitors.
+// Either way the list is marked stale until the source loads again.
+func (m *probeManager) refresh() {
+	list, err := m.source.Targets(m.ctx)
+
+	var stale *staleTargets
+	fresh := err == nil
+	switch {
+	case errors.As(err, &stale):
+		targets.setStale(true)
+		if !errors.Is(err, errCircuitOpen) {
+			slog.Warn("Using the last known good monitors", "source", m.source.Name(), "saved", stale.saved, "error", err)
+		}
+		m.sourceErr = nil
+		list = stale.list
+
+	case err != nil:
+		targets.setStale(true)
+		// The breaker logged the failures that opened it.
+		if !errors.Is(err, errCircuitOpen) {
+			slog.Error("Failed to load monitors", "source", m.source.Name(), "error", err)
+		}
+		m.sourceErr = &TargetError{Name: m.source.Name(), Error: "monitors could not be loaded, see the server logs"}
+		m.publishErrors()
+		return
+
+	default:
+		targets.setStale(false)
+		m.sourceErr = nil
+	}
+
+	m.reconcile(list, fresh)
+}
+
+func (m *probeManager) publishErrors() {
+	list := slices.Clone(m.invalid)
+	if m.sourceErr != nil {
+		list = append(list, *m.sourceErr)
+	}
+	setTargetErrors(list)
+}
+
+// reconcile brings the registry and the running workers in line with list.
+// Unless the list is fresh and not empty, nothing is deleted from KV.
+func (m *probeManager) reconcile(list []HttpRequest, fresh bool) {
+	if targets.update(list) {
+		slog.Info("Monitors updated", "count", len(list), "version", targets.Version())
+	}
+
+	trusted := fresh && len(list) > 0
+	if !m.cleaned && trusted {
+		m.cleaned = cleanStatusKeys(list)
+	}
+
+	wanted := make(map[string]HttpRequest, len(list))
+	for _, t := range list {
+		wanted[t.ID] = t
+	}
+
+	// Stop workers first, so that a monitor taking over another's state (a
+	// heartbeat token, say) finds it released.
+	for id, w := range m.workers {
+		def, ok := wanted[id]
+		if ok && !def.Paused && reflect.DeepEqual(def, w.def) {
+			continue
+		}
+
+		w.stop()
+		delete(m.workers, id)
+
+		switch {
+		case ok && def.Paused:
+			slog.Info("Monitor paused", "id", id, "name", def.Name)
+		case ok:
+			slog.Info("Monitor changed, restarting", "id", id, "name", def.Name)
+		}
+	}
+
+	for id, name := range m.known {
+		if _, ok := wanted[id]; ok {
+			delete(m.missing, id)
+			continue
+		}
+		if !trusted {
+			continue
+		}
+
+		m.missing[id]++
+		if m.missing[id] == 1 {
+			slog.Info("Monitor removed", "id", id, "name", name)
+		}
+		if m.missing[id] < forgetAfter {
+			continue
+		}
+		slog.Info("Forgetting removed monitor", "id", id, "name", name)
+		forgetMonitor(id)
+		delete(m.known, id)
+		delete(m.missing, id)
+		delete(m.errors, id)
+	}
+
+	invalid := []TargetError{}
+	for _, t := range list {
+		m.known[t.ID] = t.Name
+		if _, running := m.workers[t.ID]; running || t.Paused {
+			continue
+		}
+
+		req := t
+		var prober Prober
+		var err error
+		if t.credentialsMissing {
+			// Prepare would only fault the redacted credentials; the worker
+			// reports unknown without probing.
+			prober, err = lookupProber(t.Protocol)
+		} else {
+			prober, err = prepareTarget(&req)
+		}
+		if err != nil {
+			msg := redact(err.Error(), t.secrets())
+			if m.errors[t.ID] != msg {
+				slog.Error("Invalid monitor", "id", t.ID, "name", t.Name, "protocol", t.Protocol, "error", msg)
+			}
+			m.errors[t.ID] = msg
+			invalid = append(invalid, TargetError{ID: t.ID, Name: t.Name, Protocol: t.Protocol, Error: msg})
+			continue
+		}
+		delete(m.errors, t.ID)
+
+		m.start(t, req, prober)
+	}
+
+	sweepProbers()
+	m.invalid = invalid
+	m.publishErrors()
+}
+
+func (m *probeManager) start(def, req HttpRequest, prober Prober) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	w := &probeWorker{def: def, req: req, prober: prober, cancel: cancel, done: make(chan struct{})}
+	m.workers[def.ID] = w
+
+	interval := req.Interval
+	if interval <= 0 {
+		interval = 1 * time.Second
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer close(w.done)
+		runProbeWorker(ctx, req, prober, interval)
+	}()
+}
+
+// stop cancels the worker, waits for its probe in flight and lets its prober
+// release what it holds for the monitor.
+func (w *probeWorker) stop() {
+	w.cancel()
+	<-w.done
+	if r, ok := w.prober.(releaser); ok {
+		r.release(w.req)
+	}
+}
+
+// forgetMonitor drops the state of a deleted monitor.
+func forgetMonitor(id string) {
+	slaTrackers.Lock()
+	delete(slaTrackers.m, id)
+	slaTrackers.Unlock()
+
+	globalHub.Lock()
+	delete(globalHub.cache, id)
+	globalHub.Unlock()
+
+	deleteStatusKey(id)
+}
+
+// trackerFor returns the monitor's SLA tracker, creating it if needed, and
+// whether it already existed.
+func trackerFor(req HttpRequest) (*SlidingSLA, bool) {
+	slaTrackers.Lock()
+	defer slaTrackers.Unlock()
+
+	tracker, ok := slaTrackers.m[req.ID]
+	if !ok {
+		tracker = NewSlidingSLA(0.99999)
+		slaTrackers.m[req.ID] = tracker
+	}
+
+	tracker.mu.Lock()
+	tracker.DegradedWeight = req.DegradedWeight
+	tracker.mu.Unlock()
+	return tracker, ok
+}
+
+// -------------------- PROBE WORKER --------------------
+
+func runProbeWorker(ctx context.Context, req HttpRequest, prober Prober, interval time.Duration) {
+	confirmer := newStateConfirmer(req)
+
+	// A restarted worker keeps the tracker it had; a new one is hydrated
+	// from the last published state.
+	tracker, existed := trackerFor(req)
+
+	existingData := readFromNATS(req.ID)
+	if existingData != nil {
+		var wrapped map[string]any
+		if err := json.Unmarshal(existingData, &wrapped); err == nil {
+			if payload, ok := wrapped["payload"].(map[string]any); ok {
+				if sla, ok := payload["sla"].(map[string]any); ok && !existed {
+					if history, ok := sla["history"].([]any); ok && len(history) > 0 {
+						first := history[0].(map[string]any)
+
+						tSec := parseDurationToSecs(first["total_time_seconds"].(string))
+						dSec := parseDurationToSecs(first["down_time_seconds"].(string))
+						degraded, _ := first["degraded_time_seconds"].(string)
+
+						tracker.SetState(tSec, dSec, parseDurationToSecs(degraded))
+						slog.Info("Hydrated existing state", "name", req.Name, "uptime", first["uptime90"])
+					}
+				}
+				if probe, ok := payload["probe"].(map[string]any); ok {
+					if states, ok := probe["state"].([]any); ok && len(states) > 0 {
+						if state, _ := states[0].(string); state == hr.Up || state == hr.Warn || state == hr.Down {
+							confirmer.confirmed = state
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// check probes once and reports whether a recheck is due. A recheck adds
+	// no time to the SLA, the next tick covers it.
+	check := func(recheck bool) bool {
+		ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+
+		var res ProbeResult
+		if req.credentialsMissing {
+			res = newProbeResult(req, hr.Unknown, "credentials unavailable until the monitor source is reachable again")
+		} else {
+			start := time.Now()
+			res = redactProbeResult(req, prober.Probe(ctx, req))
+			if _, push := prober.(pushProber); !push && res.ResponseTime == 0 {
+				res.ResponseTime = millis(time.Since(start))
+			}
+			res = applyLatencyThresholds(req, res)
+		}
+		res, again := confirmer.confirm(res)
+
+		// The monitor was stopped mid-probe; its result is stale.
+		if ctx.Err() == context.Canceled {
+			return false
+		}
+
+		tracker, _ := trackerFor(req)
+
+		state := hr.Up
+		if len(res.State) > 0 {
+			state = strings.ToLower(res.State[0])
+		}
+		if !recheck {
+			tracker.Tick(state, interval)
+		}
+
+		payload := StatusPayload{
+			Probe: res,
+			SLA:   tracker.Snapshot(),
+		}
+
+		publishToNATS(ctx, req.ID, &payload, tracker)
+
+		// Broadcast update
+		globalHub.Broadcast(map[string]StatusPayload{req.ID: payload})
+
+		// Check-ins do not arrive any sooner for asking again.
+		_, push := prober.(pushProber)
+		return again && !push
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var recheckC <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Stopping probe worker", "name", req.Name)
+			return
+
+		case <-ticker.C:
+			if check(false) {
+				recheckC = time.After(recheckDelay)
+			}
+
+		case <-recheckC:
+			recheckC = nil
+			slog.Info("Rechecking unconfirmed failure", "name", req.Name)
+			check(true)
+		}
+	}
+}
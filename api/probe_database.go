@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// -------------------- DATABASE PROBES --------------------
+
+// Database probes log in with the monitor's username and password, run a
+// trivial query and report how long the login and the query took.
+
+type sqlOptions struct {
+	Database   string `json:"database"`
+	TLS        bool   `json:"tls"`
+	ServerName string `json:"serverName"`
+
+	addr string
+}
+
+type redisOptions struct {
+	Database   int    `json:"database"`
+	TLS        bool   `json:"tls"`
+	ServerName string `json:"serverName"`
+
+	addr string
+}
+
+type postgresProber struct{}
+type mysqlProber struct{}
+type redisProber struct{}
+
+func init() {
+	RegisterProber(postgresProber{}, "postgres", "postgresql")
+	RegisterProber(mysqlProber{}, "mysql", "mariadb")
+	RegisterProber(redisProber{}, "redis")
+}
+
+// withDefaultPort appends port when host does not name one.
+func withDefaultPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+func prepareSQL(req *HttpRequest, port string) error {
+	if req.Username == "" {
+		return fmt.Errorf("%s monitors require a username", normalizeProtocol(req.Protocol))
+	}
+
+	addr := withDefaultPort(req.Host, port)
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	opts := &sqlOptions{addr: addr}
+	if err := decodeConfig(req, opts); err != nil {
+		return err
+	}
+	if opts.ServerName == "" {
+		opts.ServerName = host
+	}
+
+	req.Options = opts
+	return nil
+}
+
+func databaseResult(req HttpRequest, connect, query time.Duration, version string, tlsInfo *TLSInfo) ProbeResult {
+	description := fmt.Sprintf("%s - query ok", req.Host)
+	if version != "" {
+		description = fmt.Sprintf("%s - %s - query ok", req.Host, version)
+	}
+	state, description := applyCertExpiry(req, tlsInfo, hr.Up, description)
+
+	res := newProbeResult(req, state, description)
+	res.Metrics = map[string]float64{
+		"connect_time": millis(connect),
+		"query_time":   millis(query),
+	}
+	if version != "" {
+		res.Details = map[string]string{"server_version": version}
+	}
+	res.ResponseTime = millis(connect + query)
+	if len(tlsInfo.Chain) > 0 {
+		res.TLS = tlsInfo
+	}
+	return res
+}
+
+func databaseError(req HttpRequest, stage string, err error, tlsInfo *TLSInfo) ProbeResult {
+	res := newProbeResult(req, hr.Down, fmt.Sprintf("%s - %s failed: %s", req.Host, stage, err.Error()))
+	if len(tlsInfo.Chain) > 0 {
+		res.TLS = tlsInfo
+	}
+	return res
+}
+
+// -------------------- POSTGRES --------------------
+
+func (postgresProber) Prepare(req *HttpRequest) error {
+	return prepareSQL(req, "5432")
+}
+
+func (postgresProber) Probe(ctx context.Context, req HttpRequest) ProbeResult {
+	return probePostgres(ctx, req)
+}
+
+func probePostgres(ctx context.Context, req HttpRequest) ProbeResult {
+	opts, _ := req.Options.(*sqlOptions)
+	if opts == nil {
+		opts = &sqlOptions{addr: withDefaultPort(req.Host, "5432")}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	var tlsInfo TLSInfo
+
+	// ParseConfig also reads the server's own PG* environment, which has no
+	// business in a monitor: what it could set is spelled out here or reset
+	// below.
+	cfg, err := pgx.ParseConfig("sslmode=disable sslnegotiation=postgres target_session_attrs=any")
+	if err != nil {
+		return databaseError(req, "config", err, &tlsInfo)
+	}
+	cfg.Fallbacks = nil
+	cfg.RuntimeParams = map[string]string{}
+	cfg.KerberosSrvName, cfg.KerberosSpn = "", ""
+	host, port, _ := net.SplitHostPort(opts.addr)
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return databaseError(req, "config", err, &tlsInfo)
+	}
+	cfg.Host = host
+	cfg.Port = uint16(p)
+	cfg.User = req.Username
+	cfg.Password = req.Password
+	cfg.Database = opts.Database
+	cfg.ConnectTimeout = defaultTimeout
+	if userAgent != "" {
+		cfg.RuntimeParams["application_name"] = userAgent
+	}
+	if opts.TLS {
+		cfg.TLSConfig = tlsProbeConfig(opts.ServerName, &tlsInfo)
+	}
+
+	start := time.Now()
+	conn, err := pgx.ConnectConfig(ctx, cfg)
+	if err != nil {
+		return databaseError(req, "connect", err, &tlsInfo)
+	}
+	defer conn.Close(context.Background())
+	connect := time.Since(start)
+
+	start = time.Now()
+	var one int
+	if err := conn.QueryRow(ctx, "SELECT 1").Scan(&one); err != nil {
+		return databaseError(req, "query", err, &tlsInfo)
+	}
+	query := time.Since(start)
+
+	version := conn.PgConn().ParameterStatus("server_version")
+	if version != "" {
+		version = "PostgreSQL " + version
+	}
+	return databaseResult(req, connect, query, version, &tlsInfo)
+}
+
+// -------------------- MYSQL --------------------
+
+func (mysqlProber) Prepare(req *HttpRequest) error {
+	return prepareSQL(req, "3306")
+}
+
+func (mysqlProber) Probe(ctx context.Context, req HttpRequest) ProbeResult {
+	return probeMySQL(ctx, req)
+}
+
+func probeMySQL(ctx context.Context, req HttpRequest) ProbeResult {
+	opts, _ := req.Options.(*sqlOptions)
+	if opts == nil {
+		opts = &sqlOptions{addr: withDefaultPort(req.Host, "3306")}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	var tlsInfo TLSInfo
+
+	cfg := mysql.NewConfig()
+	cfg.Net = "tcp"
+	cfg.Addr = opts.addr
+	cfg.User = req.Username
+	cfg.Passwd = req.Password
+	cfg.DBName = opts.Database
+	cfg.Timeout = defaultTimeout
+	if opts.TLS {
+		cfg.TLS = tlsProbeConfig(opts.ServerName, &tlsInfo)
+	}
+
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return databaseError(req, "config", err, &tlsInfo)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	start := time.Now()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return databaseError(req, "connect", err, &tlsInfo)
+	}
+	defer conn.Close()
+	connect := time.Since(start)
+
+	start = time.Now()
+	var one int
+	if err := conn.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return databaseError(req, "query", err, &tlsInfo)
+	}
+	query := time.Since(start)
+
+	var version string
+	if err := conn.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err == nil {
+		version = ternary(strings.Contains(version, "MariaDB"), "MariaDB ", "MySQL ") + strings.TrimSuffix(version, "-MariaDB")
+	}
+	return databaseResult(req, connect, query, version, &tlsInfo)
+}
+
+// -------------------- REDIS --------------------
+
+func (redisProber) Prepare(req *HttpRequest) error {
+	addr := withDefaultPort(req.Host, "6379")
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	opts := &redisOptions{addr: addr}
+	if err := decodeConfig(req, opts); err != nil {
+		return err
+	}
+	if opts.Database < 0 {
+		return errors.New("redis database must not be negative")
+	}
+	if opts.ServerName == "" {
+		opts.ServerName = host
+	}
+
+	req.Options = opts
+	return nil
+}
+
+func (redisProber) Probe(ctx context.Context, req HttpRequest) ProbeResult {
+	return probeRedis(ctx, req)
+}
+
+func probeRedis(ctx context.Context, req HttpRequest) ProbeResult {
+	opts, _ := req.Options.(*redisOptions)
+	if opts == nil {
+		opts = &redisOptions{addr: withDefaultPort(req.Host, "6379")}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	var tlsInfo TLSInfo
+	var tlsConfig *tls.Config
+	if opts.TLS {
+		tlsConfig = tlsProbeConfig(opts.ServerName, &tlsInfo)
+	}
+
+	// The first command dials and authenticates; OnConnect marks the end of
+	// that so connect and query latency can be told apart.
+	var connected time.Time
+	client := redis.NewClient(&redis.Options{
+		Addr:            opts.addr,
+		Username:        req.Username,
+		Password:        req.Password,
+		DB:              opts.Database,
+		TLSConfig:       tlsConfig,
+		DialTimeout:     defaultTimeout,
+		MaxRetries:      -1,
+		PoolSize:        1,
+		DisableIdentity: true,
+		OnConnect: func(context.Context, *redis.Conn) error {
+			connected = time.Now()
+			return nil
+		},
+	})
+	defer client.Close()
+
+	start := time.Now()
+	if err := client.Ping(ctx).Err(); err != nil {
+		stage := "connect"
+		if !connected.IsZero() {
+			stage = "PING"
+		}
+		return databaseError(req, stage, err, &tlsInfo)
+	}
+	end := time.Now()
+
+	version := ""
+	if info, err := client.Info(ctx, "server").Result(); err == nil {
+		for line := range strings.SplitSeq(info, "\n") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(line), "redis_version:"); ok {
+				version = "Redis " + v
+				break
+			}
+		}
+	}
+	return databaseResult(req, connected.Sub(start), end.Sub(connected), version, &tlsInfo)
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// -------------------- DNS PROBE --------------------
+
+const (
+	DNSMatchExact    = "exact"
+	DNSMatchContains = "contains"
+	DNSMatchAny      = "any"
+)
+
+var dnsRecordTypes = map[string]uint16{
+	"A":     dns.TypeA,
+	"AAAA":  dns.TypeAAAA,
+	"CNAME": dns.TypeCNAME,
+	"MX":    dns.TypeMX,
+	"TXT":   dns.TypeTXT,
+	"NS":    dns.TypeNS,
+	"SRV":   dns.TypeSRV,
+	"CAA":   dns.TypeCAA,
+}
+
+type dnsOptions struct {
+	RecordType string   `json:"recordType"`
+	Nameserver string   `json:"nameserver"`
+	Transport  string   `json:"transport"`
+	Expected   []string `json:"expected"`
+	Match      string   `json:"match"`
+}
+
+type DNSResult struct {
+	Server     string      `json:"server"`
+	RecordType string      `json:"record_type"`
+	Rcode      string      `json:"rcode"`
+	Answers    []DNSAnswer `json:"answers,omitempty"`
+}
+
+type DNSAnswer struct {
+	Value string `json:"value"`
+	TTL   uint32 `json:"ttl"`
+}
+
+type dnsProber struct{}
+
+func init() {
+	RegisterProber(dnsProber{}, "dns")
+}
+
+func (dnsProber) Prepare(req *HttpRequest) error {
+	opts := &dnsOptions{}
+	if err := decodeConfig(req, opts); err != nil {
+		return err
+	}
+
+	opts.RecordType = strings.ToUpper(strings.TrimSpace(opts.RecordType))
+	if opts.RecordType != "" {
+		if _, ok := dnsRecordTypes[opts.RecordType]; !ok {
+			return fmt.Errorf("unsupported DNS record type %q", opts.RecordType)
+		}
+	}
+
+	switch opts.Match = strings.ToLower(opts.Match); opts.Match {
+	case "":
+		opts.Match = DNSMatchExact
+	case DNSMatchExact, DNSMatchContains, DNSMatchAny:
+	default:
+		return fmt.Errorf("unsupported DNS match mode %q", opts.Match)
+	}
+
+	switch opts.Transport = strings.ToLower(opts.Transport); opts.Transport {
+	case "", "udp", "tcp":
+	default:
+		return fmt.Errorf("unsupported DNS transport %q", opts.Transport)
+	}
+
+	if opts.Nameserver != "" {
+		if _, _, err := net.SplitHostPort(opts.Nameserver); err != nil {
+			opts.Nameserver = net.JoinHostPort(opts.Nameserver, "53")
+		}
+	}
+
+	req.Options = opts
+	return nil
+}
+
+func (dnsProber) Probe(ctx context.Context, req HttpRequest) ProbeResult {
+	return probeDNS(ctx, req)
+}
+
+func probeDNS(ctx context.Context, req HttpRequest) ProbeResult {
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	opts, _ := req.Options.(*dnsOptions)
+	if opts == nil {
+		opts = &dnsOptions{Match: DNSMatchExact}
+	}
+
+	if net.ParseIP(req.Host) != nil {
+		return newProbeResult(req, hr.Warn, "Input is already an IP, DNS lookup skipped")
+	}
+
+	query := func(recordType string) (*DNSResult, time.Duration, error) {
+		return queryServers(ctx, req.Host, recordType, []string{opts.Nameserver}, opts.Transport)
+	}
+	if opts.Nameserver == "" {
+		if servers, err := systemNameservers(); err == nil {
+			query = func(recordType string) (*DNSResult, time.Duration, error) {
+				return queryServers(ctx, req.Host, recordType, servers, opts.Transport)
+			}
+		} else {
+			// Without a resolv.conf to read, the system resolver answers.
+			query = func(recordType string) (*DNSResult, time.Duration, error) {
+				return lookupSystem(ctx, req.Host, recordType)
+			}
+		}
+	}
+
+	types := []string{opts.RecordType}
+	if opts.RecordType == "" {
+		// Like LookupHost: fall back to AAAA for IPv6-only names.
+		types = []string{"A", "AAAA"}
+	}
+
+	var result *DNSResult
+	var rtt time.Duration
+	for _, recordType := range types {
+		var err error
+		result, rtt, err = query(recordType)
+		if err != nil {
+			res := newProbeResult(req, hr.Down, fmt.Sprintf("DNS error: %s", err.Error()))
+			res.DNS = result
+			return res
+		}
+		if len(result.Answers) > 0 {
+			break
+		}
+	}
+
+	res := newProbeResult(req, hr.Up, "")
+	res.DNS = result
+	res.ResponseTime = millis(rtt)
+
+	values := make([]string, 0, len(result.Answers))
+	for _, a := range result.Answers {
+		values = append(values, a.Value)
+	}
+
+	switch {
+	case result.Rcode != dns.RcodeToString[dns.RcodeSuccess]:
+		res.State = []string{hr.Down}
+		res.Description = fmt.Sprintf("DNS error: %s from %s", result.Rcode, result.Server)
+
+	case len(values) == 0:
+		res.State = []string{hr.Down}
+		res.Description = fmt.Sprintf("no %s records from %s", result.RecordType, result.Server)
+
+	default:
+		if missing := unmatchedAnswers(values, opts.Expected, opts.Match, result.RecordType); len(missing) > 0 {
+			res.State = []string{hr.Down}
+			res.Description = fmt.Sprintf("unexpected %s answer %v from %s, expected %s %v", result.RecordType, values, result.Server, opts.Match, opts.Expected)
+		} else {
+			res.Description = fmt.Sprintf("resolved %s %v via %s", result.RecordType, values, result.Server)
+		}
+	}
+
+	return res
+}
+
+// queryServers asks the servers in turn, like the system resolver walks
+// resolv.conf, until one answers with something other than a server failure.
+func queryServers(ctx context.Context, host, recordType string, servers []string, transport string) (*DNSResult, time.Duration, error) {
+	var result *DNSResult
+	var rtt time.Duration
+	var err error
+	for _, server := range servers {
+		qctx, cancel := context.WithTimeout(ctx, defaultTimeout/time.Duration(len(servers)))
+		result, rtt, err = queryDNS(qctx, host, recordType, server, transport)
+		cancel()
+
+		if ctx.Err() != nil {
+			break
+		}
+		if err == nil && result.Rcode != dns.RcodeToString[dns.RcodeServerFailure] && result.Rcode != dns.RcodeToString[dns.RcodeRefused] {
+			break
+		}
+	}
+	return result, rtt, err
+}
+
+func queryDNS(ctx context.Context, host, recordType, server, transport string) (*DNSResult, time.Duration, error) {
+	qtype := dnsRecordTypes[recordType]
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(host), qtype)
+	msg.RecursionDesired = true
+
+	if transport == "" {
+		transport = "udp"
+	}
+
+	client := &dns.Client{Net: transport, Timeout: defaultTimeout}
+	in, rtt, err := client.ExchangeContext(ctx, msg, server)
+	if err == nil && in.Truncated && transport == "udp" {
+		client.Net = "tcp"
+		in, rtt, err = client.ExchangeContext(ctx, msg, server)
+	}
+
+	result := &DNSResult{Server: server, RecordType: recordType}
+	if err != nil {
+		return result, rtt, err
+	}
+
+	result.Rcode = dns.RcodeToString[in.Rcode]
+	for _, rr := range in.Answer {
+		if rr.Header().Rrtype != qtype {
+			continue
+		}
+		result.Answers = append(result.Answers, DNSAnswer{Value: dnsValue(rr), TTL: rr.Header().Ttl})
+	}
+	return result, rtt, nil
+}
+
+func dnsValue(rr dns.RR) string {
+	switch r := rr.(type) {
+	case *dns.A:
+		return r.A.String()
+	case *dns.AAAA:
+		return r.AAAA.String()
+	case *dns.CNAME:
+		return strings.TrimSuffix(r.Target, ".")
+	case *dns.NS:
+		return strings.TrimSuffix(r.Ns, ".")
+	case *dns.MX:
+		return fmt.Sprintf("%d %s", r.Preference, strings.TrimSuffix(r.Mx, "."))
+	case *dns.TXT:
+		return strings.Join(r.Txt, "")
+	case *dns.SRV:
+		return fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, strings.TrimSuffix(r.Target, "."))
+	case *dns.CAA:
+		return fmt.Sprintf("%d %s %s", r.Flag, r.Tag, strconv.Quote(r.Value))
+	}
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+// unmatchedAnswers returns the expectations the answers violate. "exact"
+// wants the same set, "contains" every expected value and "any" at least one.
+func unmatchedAnswers(answers, expected []string, match, recordType string) []string {
+	if len(expected) == 0 {
+		return nil
+	}
+
+	got := make([]string, 0, len(answers))
+	for _, a := range answers {
+		got = append(got, normalizeDNSValue(a, recordType))
+	}
+
+	missing := []string{}
+	for _, e := range expected {
+		if !slices.Contains(got, normalizeDNSValue(e, recordType)) {
+			missing = append(missing, e)
+		}
+	}
+
+	switch match {
+	case DNSMatchAny:
+		if len(missing) < len(expected) {
+			return nil
+		}
+		return missing
+
+	case DNSMatchExact:
+		want := make([]string, 0, len(expected))
+		for _, e := range expected {
+			want = append(want, normalizeDNSValue(e, recordType))
+		}
+		for i, g := range got {
+			if !slices.Contains(want, g) {
+				missing = append(missing, answers[i])
+			}
+		}
+	}
+	return missing
+}
+
+// normalizeDNSValue makes names case-insensitive; TXT data is compared as is.
+func normalizeDNSValue(s, recordType string) string {
+	s = strings.TrimSpace(s)
+	if recordType == "TXT" {
+		return s
+	}
+	return strings.TrimSuffix(strings.ToLower(s), ".")
+}
+
+func systemNameservers() ([]string, error) {
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return nil, err
+	}
+	if len(conf.Servers) == 0 {
+		return nil, errors.New("no nameservers in /etc/resolv.conf")
+	}
+	servers := make([]string, 0, len(conf.Servers))
+	for _, server := range conf.Servers {
+		servers = append(servers, net.JoinHostPort(server, conf.Port))
+	}
+	return servers, nil
+}
+
+// lookupSystem queries through net.DefaultResolver, which reports neither
+// TTLs nor CAA records.
+func lookupSystem(ctx context.Context, host, recordType string) (*DNSResult, time.Duration, error) {
+	result := &DNSResult{Server: "system resolver", RecordType: recordType, Rcode: dns.RcodeToString[dns.RcodeSuccess]}
+	resolver := net.DefaultResolver
+	start := time.Now()
+
+	var values []string
+	var err error
+	switch recordType {
+	case "A", "AAAA":
+		network := "ip4"
+		if recordType == "AAAA" {
+			network = "ip6"
+		}
+		var ips []net.IP
+		ips, err = resolver.LookupIP(ctx, network, host)
+		for _, ip := range ips {
+			values = append(values, ip.String())
+		}
+	case "CNAME":
+		var cname string
+		cname, err = resolver.LookupCNAME(ctx, host)
+		if cname != "" && cname != dns.Fqdn(host) {
+			values = append(values, strings.TrimSuffix(cname, "."))
+		}
+	case "MX":
+		var records []*net.MX
+		records, err = resolver.LookupMX(ctx, host)
+		for _, r := range records {
+			values = append(values, fmt.Sprintf("%d %s", r.Pref, strings.TrimSuffix(r.Host, ".")))
+		}
+	case "TXT":
+		values, err = resolver.LookupTXT(ctx, host)
+	case "NS":
+		var records []*net.NS
+		records, err = resolver.LookupNS(ctx, host)
+		for _, r := range records {
+			values = append(values, strings.TrimSuffix(r.Host, "."))
+		}
+	case "SRV":
+		var records []*net.SRV
+		_, records, err = resolver.LookupSRV(ctx, "", "", host)
+		for _, r := range records {
+			values = append(values, fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, strings.TrimSuffix(r.Target, ".")))
+		}
+	default:
+		return result, 0, fmt.Errorf("%s lookups need a nameserver and none was found in /etc/resolv.conf", recordType)
+	}
+	rtt := time.Since(start)
+
+	// A missing name and a name without such records both read as no records.
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return result, rtt, nil
+	}
+	if err != nil {
+		return result, rtt, err
+	}
+	for _, v := range values {
+		result.Answers = append(result.Answers, DNSAnswer{Value: v})
+	}
+	return result, rtt, nil
+}
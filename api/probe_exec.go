@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -------------------- EXEC PROBE --------------------
+
+// Exec monitors run a check script with Nagios plugin semantics. Monitors
+// come from the database, so only executables inside EXEC_PROBES_DIR can be
+// run, without a shell and without the server's environment. The monitor's
+// credentials are passed as BEEP_USERNAME and BEEP_PASSWORD, and {{host}}
+// in an argument is replaced with the monitor's host.
+
+const (
+	defaultExecTimeout = 10 * time.Second
+	maxExecOutput      = 64 * 1024
+)
+
+var execProbesDir = os.Getenv("EXEC_PROBES_DIR")
+
+type execOptions struct {
+	Command []string `json:"command"`
+	Timeout int64    `json:"timeout"`
+
+	path    string
+	timeout time.Duration
+}
+
+type execProber struct{}
+
+func init() {
+	RegisterProber(execProber{}, "exec")
+}
+
+func (execProber) Prepare(req *HttpRequest) error {
+	if execProbesDir == "" {
+		return errors.New("exec monitors are disabled, set EXEC_PROBES_DIR to enable them")
+	}
+
+	opts := &execOptions{}
+	if err := decodeConfig(req, opts); err != nil {
+		return err
+	}
+
+	if len(opts.Command) == 0 || opts.Command[0] == "" {
+		return errors.New("exec monitors need a command")
+	}
+	name := opts.Command[0]
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return fmt.Errorf("command %q must be a file name inside EXEC_PROBES_DIR", name)
+	}
+
+	opts.path = filepath.Join(execProbesDir, name)
+	info, err := os.Stat(opts.path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() || info.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("%s is not executable", opts.path)
+	}
+
+	opts.timeout = defaultExecTimeout
+	if opts.Timeout > 0 {
+		opts.timeout = time.Duration(opts.Timeout) * time.Millisecond
+	}
+	if opts.timeout > defaultTimeout {
+		return fmt.Errorf("exec timeout must be at most %s", defaultTimeout)
+	}
+
+	req.Options = opts
+	return nil
+}
+
+func (execProber) Probe(ctx context.Context, req HttpRequest) ProbeResult {
+	return probeExec(ctx, req)
+}
+
+func probeExec(ctx context.Context, req HttpRequest) ProbeResult {
+	opts, _ := req.Options.(*execOptions)
+	if opts == nil {
+		return newProbeResult(req, hr.Down, "exec monitor was not prepared")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	args := make([]string, 0, len(opts.Command)-1)
+	for _, arg := range opts.Command[1:] {
+		args = append(args, expandVars(arg, map[string]string{"host": req.Host}))
+	}
+
+	cmd := exec.CommandContext(ctx, opts.path, args...)
+	cmd.Dir = execProbesDir
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"BEEP_MONITOR=" + req.Name,
+		"BEEP_HOST=" + req.Host,
+		"BEEP_USERNAME=" + req.Username,
+		"BEEP_PASSWORD=" + req.Password,
+	}
+	// Do not wait forever on pipes held open by a killed script's children.
+	cmd.WaitDelay = time.Second
+
+	stdout := &limitedBuffer{limit: maxExecOutput}
+	stderr := &limitedBuffer{limit: maxExecOutput}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	elapsed := time.Since(start)
+
+	code := 0
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		res := newProbeResult(req, hr.Down, fmt.Sprintf("%s timed out after %s", opts.Command[0], opts.timeout))
+		res.ResponseTime = millis(elapsed)
+		return res
+	case errors.As(err, &exitErr):
+		code = exitErr.ExitCode()
+	case err != nil:
+		return newProbeResult(req, hr.Down, fmt.Sprintf("%s failed to run: %s", opts.Command[0], err.Error()))
+	}
+
+	state := pluginState(code)
+
+	text, perfdata := parsePluginOutput(stdout.String())
+	if text == "" {
+		text = firstLine(stderr.String())
+	}
+	if text == "" {
+		text = fmt.Sprintf("%s exited with status %d", opts.Command[0], code)
+	}
+
+	res := newProbeResult(req, state, truncate(text, 200))
+	res.ResponseTime = millis(elapsed)
+	res.Details = map[string]string{"exit_code": strconv.Itoa(code)}
+	if len(perfdata) > 0 {
+		res.Metrics = perfdata
+	}
+	return res
+}
+
+// pluginState maps Nagios plugin return codes; anything unexpected is
+// unknown, as Nagios itself treats it.
+func pluginState(code int) string {
+	switch code {
+	case 0:
+		return hr.Up
+	case 1:
+		return hr.Warn
+	case 2:
+		return hr.Down
+	}
+	return hr.Unknown
+}
+
+// parsePluginOutput splits Nagios plugin output into the first line of text
+// and the performance data found after "|" on any line.
+func parsePluginOutput(out string) (string, map[string]float64) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+
+	text := ""
+	perfdata := map[string]float64{}
+	for i, line := range lines {
+		line, perf, _ := strings.Cut(line, "|")
+		if i == 0 {
+			text = strings.TrimSpace(line)
+		}
+		maps.Copy(perfdata, parsePerfdata(perf))
+	}
+	return text, perfdata
+}
+
+// parsePerfdata reads 'label'=value[UOM];warn;crit;min;max entries, keeping
+// the value. Labels may be quoted, with ” standing for a literal quote.
+func parsePerfdata(s string) map[string]float64 {
+	out := map[string]float64{}
+	s = strings.TrimSpace(s)
+	for s != "" {
+		var label string
+		if strings.HasPrefix(s, "'") {
+			end := 1
+			for end < len(s) {
+				if s[end] == '\'' {
+					if end+1 < len(s) && s[end+1] == '\'' {
+						end += 2
+						continue
+					}
+					break
+				}
+				end++
+			}
+			label = strings.ReplaceAll(s[1:min(end, len(s))], "''", "'")
+			s = s[min(end+1, len(s)):]
+		} else {
+			i := strings.IndexByte(s, '=')
+			if i < 0 {
+				break
+			}
+			label, s = s[:i], s[i:]
+		}
+
+		rest, ok := strings.CutPrefix(s, "=")
+		if !ok {
+			break
+		}
+		field, remainder, _ := strings.Cut(rest, " ")
+		s = strings.TrimSpace(remainder)
+
+		raw, _, _ := strings.Cut(field, ";")
+		raw = strings.TrimRight(raw, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ%")
+		if value, err := strconv.ParseFloat(strings.ReplaceAll(raw, ",", "."), 64); err == nil && label != "" {
+			out[label] = value
+		}
+	}
+	return out
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(line)
+}
+
+// limitedBuffer keeps the first limit bytes written and discards the rest,
+// so a chatty script cannot exhaust memory.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// -------------------- GRPC PROBE --------------------
+
+type grpcOptions struct {
+	Service    string            `json:"service"`
+	TLS        bool              `json:"tls"`
+	ServerName string            `json:"serverName"`
+	Metadata   map[string]string `json:"metadata"`
+}
+
+type grpcProber struct{}
+
+func init() {
+	RegisterProber(grpcProber{}, "grpc")
+}
+
+func (grpcProber) Prepare(req *HttpRequest) error {
+	host, _, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		return fmt.Errorf("grpc host must be host:port: %w", err)
+	}
+
+	opts := &grpcOptions{}
+	if err := decodeConfig(req, opts); err != nil {
+		return err
+	}
+
+	if opts.ServerName == "" {
+		opts.ServerName = host
+	}
+
+	req.Options = opts
+	return nil
+}
+
+func (grpcProber) Probe(ctx context.Context, req HttpRequest) ProbeResult {
+	return probeGRPC(ctx, req)
+}
+
+// probeGRPC calls grpc.health.v1.Health/Check. An empty service asks about
+// the server as a whole.
+func probeGRPC(ctx context.Context, req HttpRequest) ProbeResult {
+	opts, _ := req.Options.(*grpcOptions)
+	if opts == nil {
+		opts = &grpcOptions{}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	var tlsInfo TLSInfo
+	creds := insecure.NewCredentials()
+	if opts.TLS {
+		creds = credentials.NewTLS(tlsProbeConfig(opts.ServerName, &tlsInfo))
+	}
+
+	ua := req.UserAgent
+	if ua == "" {
+		ua = userAgent
+	}
+
+	conn, err := grpc.NewClient(req.Host, grpc.WithTransportCredentials(creds), grpc.WithUserAgent(ua))
+	if err != nil {
+		return newProbeResult(req, hr.Down, err.Error())
+	}
+	defer conn.Close()
+
+	if len(opts.Metadata) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(opts.Metadata))
+	}
+
+	start := time.Now()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: opts.Service})
+	elapsed := time.Since(start)
+
+	var res ProbeResult
+	if err != nil {
+		st := status.Convert(err)
+		description := fmt.Sprintf("%s: %s", st.Code(), st.Message())
+		switch st.Code() {
+		case codes.NotFound:
+			description = fmt.Sprintf("unknown service %q", opts.Service)
+		case codes.Unimplemented:
+			description = "server does not implement grpc.health.v1.Health"
+		}
+		res = newProbeResult(req, hr.Down, description)
+	} else {
+		state := hr.Warn
+		switch resp.GetStatus() {
+		case healthpb.HealthCheckResponse_SERVING:
+			state = hr.Up
+		case healthpb.HealthCheckResponse_NOT_SERVING, healthpb.HealthCheckResponse_SERVICE_UNKNOWN:
+			state = hr.Down
+		}
+
+		target := opts.Service
+		if target == "" {
+			target = "server"
+		}
+		description := fmt.Sprintf("%s %s", target, resp.GetStatus())
+		state, description = applyCertExpiry(req, &tlsInfo, state, description)
+		res = newProbeResult(req, state, description)
+	}
+
+	res.ResponseTime = millis(elapsed)
+	if len(tlsInfo.Chain) > 0 {
+		res.TLS = &tlsInfo
+	}
+	return res
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -------------------- HEARTBEAT MONITORS --------------------
+
+// Heartbeat monitors are pushed to rather than probed: a job checks in at
+// POST /v1/heartbeat/{token} and the monitor goes down once a check-in is
+// overdue. The monitor's interval is the expected time between check-ins.
+// The last check-in is published with the monitor's status, and read back
+// from it after a restart.
+
+const (
+	defaultHeartbeatGrace = 60 * time.Second
+	// heartbeatPollInterval bounds how late an overdue check-in is noticed
+	// when the expected interval is long (a nightly job, say).
+	heartbeatPollInterval = 30 * time.Second
+	minHeartbeatToken     = 16
+	maxHeartbeatMessage   = 1024
+)
+
+type heartbeatOptions struct {
+	Token string `json:"token"`
+	// Grace is how many seconds a check-in may be late.
+	Grace int64 `json:"grace"`
+
+	period time.Duration
+	grace  time.Duration
+}
+
+type heartbeat struct {
+	id   string
+	name string
+	// owner is the running monitor's options, nil once its worker stopped.
+	owner    *heartbeatOptions
+	since    time.Time
+	last     time.Time
+	failed   bool
+	message  string
+	checkIns int64
+}
+
+var heartbeats = struct {
+	sync.RWMutex
+	m map[string]*heartbeat
+	// hydrated lists the monitors whose saved check-in was already read.
+	hydrated map[string]bool
+}{m: make(map[string]*heartbeat), hydrated: make(map[string]bool)}
+
+type heartbeatProber struct{}
+
+func init() {
+	RegisterProber(heartbeatProber{}, "heartbeat")
+}
+
+// pushed marks heartbeatProber as needing no host.
+func (heartbeatProber) pushed() {}
+
+func (heartbeatProber) Prepare(req *HttpRequest) error {
+	opts := &heartbeatOptions{}
+	if err := decodeConfig(req, opts); err != nil {
+		return err
+	}
+
+	opts.Token = strings.TrimSpace(opts.Token)
+	if len(opts.Token) < minHeartbeatToken {
+		return fmt.Errorf("heartbeat token must be at least %d characters", minHeartbeatToken)
+	}
+	if strings.ContainsAny(opts.Token, "/?#") {
+		return errors.New("heartbeat token must not contain /, ? or #")
+	}
+	if req.Interval <= 0 {
+		return errors.New("heartbeat monitors need an interval")
+	}
+
+	opts.grace = defaultHeartbeatGrace
+	if opts.Grace > 0 {
+		opts.grace = time.Duration(opts.Grace) * time.Second
+	}
+
+	heartbeats.RLock()
+	hydrated := heartbeats.hydrated[req.ID]
+	heartbeats.RUnlock()
+	var saved *heartbeat
+	if !hydrated {
+		saved = savedHeartbeat(req.ID)
+	}
+
+	// An edited or renamed monitor takes over the check-ins of the token.
+	heartbeats.Lock()
+	defer heartbeats.Unlock()
+	hb, ok := heartbeats.m[opts.Token]
+	switch {
+	case !ok:
+		hb = &heartbeat{since: time.Now()}
+		if saved != nil {
+			hb.last, hb.failed, hb.message, hb.checkIns = saved.last, saved.failed, saved.message, saved.checkIns
+		}
+		heartbeats.m[opts.Token] = hb
+	case hb.owner != nil && hb.id != req.ID:
+		return fmt.Errorf("heartbeat token already used by %q", hb.name)
+	}
+	heartbeats.hydrated[req.ID] = true
+	hb.id = req.ID
+	hb.name = req.Name
+	hb.owner = opts
+
+	opts.period = req.Interval
+	req.Interval = min(req.Interval, heartbeatPollInterval)
+	req.Options = opts
+	return nil
+}
+
+func (heartbeatProber) release(req HttpRequest) {
+	opts, _ := req.Options.(*heartbeatOptions)
+	if opts == nil {
+		return
+	}
+
+	heartbeats.Lock()
+	defer heartbeats.Unlock()
+	if hb, ok := heartbeats.m[opts.Token]; ok && hb.owner == opts {
+		hb.owner = nil
+	}
+}
+
+// sweep forgets the tokens of monitors that were deleted or given a new token.
+func (heartbeatProber) sweep() {
+	heartbeats.Lock()
+	defer heartbeats.Unlock()
+	for token, hb := range heartbeats.m {
+		if hb.owner == nil {
+			delete(heartbeats.m, token)
+		}
+	}
+}
+
+func (heartbeatProber) Probe(_ context.Context, req HttpRequest) ProbeResult {
+	return probeHeartbeat(req, time.Now())
+}
+
+func probeHeartbeat(req HttpRequest, now time.Time) ProbeResult {
+	opts, _ := req.Options.(*heartbeatOptions)
+	if opts == nil {
+		return newProbeResult(req, hr.Down, "heartbeat monitor was not prepared")
+	}
+
+	heartbeats.RLock()
+	hb := heartbeats.m[opts.Token]
+	var snapshot heartbeat
+	if hb != nil {
+		snapshot = *hb
+	}
+	heartbeats.RUnlock()
+
+	deadline := opts.period + opts.grace
+
+	var res ProbeResult
+	switch {
+	case snapshot.last.IsZero() && now.Sub(snapshot.since) <= deadline:
+		res = newProbeResult(req, hr.Up, fmt.Sprintf("waiting for first check-in, due within %s", (deadline-now.Sub(snapshot.since)).Round(time.Second)))
+	case snapshot.last.IsZero():
+		res = newProbeResult(req, hr.Down, fmt.Sprintf("no check-in received in %s", now.Sub(snapshot.since).Round(time.Second)))
+	case snapshot.failed:
+		description := fmt.Sprintf("job reported failure %s ago", now.Sub(snapshot.last).Round(time.Second))
+		if snapshot.message != "" {
+			description = fmt.Sprintf("%s: %s", description, truncate(snapshot.message, 200))
+		}
+		res = newProbeResult(req, hr.Down, description)
+	case now.Sub(snapshot.last) > deadline:
+		res = newProbeResult(req, hr.Down, fmt.Sprintf("last check-in %s ago, expected every %s (+%s grace)", now.Sub(snapshot.last).Round(time.Second), opts.period, opts.grace))
+	default:
+		description := fmt.Sprintf("last check-in %s ago", now.Sub(snapshot.last).Round(time.Second))
+		if snapshot.message != "" {
+			description = fmt.Sprintf("%s: %s", description, truncate(snapshot.message, 200))
+		}
+		res = newProbeResult(req, hr.Up, description)
+	}
+
+	res.Metrics = map[string]float64{"check_ins": float64(snapshot.checkIns)}
+	if !snapshot.last.IsZero() {
+		res.Metrics["seconds_since_check_in"] = now.Sub(snapshot.last).Round(time.Second).Seconds()
+		res.Details = map[string]string{
+			"last_check_in": snapshot.last.UTC().Format(time.RFC3339Nano),
+			"failed":        strconv.FormatBool(snapshot.failed),
+		}
+		if snapshot.message != "" {
+			res.Details["message"] = truncate(snapshot.message, 200)
+		}
+	}
+	return res
+}
+
+// savedHeartbeat reads the last check-in back from the monitor's published
+// status, nil if there is none.
+func savedHeartbeat(id string) *heartbeat {
+	data := readFromNATS(id)
+	if data == nil {
+		return nil
+	}
+
+	var wrapped struct {
+		Payload StatusPayload `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return nil
+	}
+	probe := wrapped.Payload.Probe
+	last, err := time.Parse(time.RFC3339Nano, probe.Details["last_check_in"])
+	if err != nil {
+		return nil
+	}
+	return &heartbeat{
+		last:     last,
+		failed:   probe.Details["failed"] == "true",
+		message:  probe.Details["message"],
+		checkIns: int64(probe.Metrics["check_ins"]),
+	}
+}
+
+// checkIn records a ping for token and reports whether the token is known.
+func checkIn(token string, failed bool, message string) (string, bool) {
+	heartbeats.Lock()
+	defer heartbeats.Unlock()
+
+	hb, ok := heartbeats.m[token]
+	if !ok || hb.owner == nil {
+		return "", false
+	}
+	hb.last = time.Now()
+	hb.failed = failed
+	hb.message = message
+	hb.checkIns++
+	return hb.name, true
+}
+
+// -------------------- HEARTBEAT HANDLER --------------------
+
+// HeartbeatHandler accepts check-ins. The request body, if any, is kept as a
+// short status message; POST .../fail reports that the job failed.
+func HeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(HeaderContentType, ContentTypeJSON)
+
+	failed := strings.HasSuffix(r.URL.Path, "/fail")
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxHeartbeatMessage))
+	if err != nil {
+		w.WriteHeader(StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(ErrorResponse{State: []string{"error"}, Message: "could not read request body"})
+		return
+	}
+
+	name, ok := checkIn(r.PathValue("token"), failed, strings.TrimSpace(string(body)))
+	if !ok {
+		w.WriteHeader(StatusNotFound)
+		_ = json.NewEncoder(w).Encode(ErrorResponse{State: []string{"error"}, Message: "unknown heartbeat token"})
+		return
+	}
+
+	slog.Info("Heartbeat received", "name", name, "failed", failed)
+
+	w.WriteHeader(StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"name":   name,
+		"failed": failed,
+	})
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// -------------------- ICMP PROBE --------------------
+
+const (
+	defaultPingCount    = 4
+	defaultPingInterval = 200 * time.Millisecond
+	defaultPingTimeout  = 1 * time.Second
+	defaultPingSize     = 56
+)
+
+type icmpOptions struct {
+	Count    int   `json:"count"`
+	Interval int64 `json:"interval"`
+	Timeout  int64 `json:"timeout"`
+	Size     int   `json:"size"`
+
+	interval time.Duration
+	timeout  time.Duration
+}
+
+type icmpProber struct{}
+
+func init() {
+	RegisterProber(icmpProber{}, "icmp", "ping")
+}
+
+func (icmpProber) Prepare(req *HttpRequest) error {
+	opts := &icmpOptions{}
+	if err := decodeConfig(req, opts); err != nil {
+		return err
+	}
+
+	if opts.Count <= 0 {
+		opts.Count = defaultPingCount
+	}
+	if opts.Count > 100 {
+		return errors.New("icmp count must be at most 100")
+	}
+	if opts.Size <= 0 {
+		opts.Size = defaultPingSize
+	}
+	if opts.Size < 16 || opts.Size > 1472 {
+		return errors.New("icmp size must be between 16 and 1472 bytes")
+	}
+
+	opts.interval = defaultPingInterval
+	if opts.Interval > 0 {
+		opts.interval = time.Duration(opts.Interval) * time.Millisecond
+	}
+	opts.timeout = defaultPingTimeout
+	if opts.Timeout > 0 {
+		opts.timeout = time.Duration(opts.Timeout) * time.Millisecond
+	}
+
+	if total := time.Duration(opts.Count)*opts.interval + opts.timeout; total > defaultTimeout {
+		return fmt.Errorf("icmp count, interval and timeout add up to %s, above the %s probe timeout", total, defaultTimeout)
+	}
+
+	req.Options = opts
+	return nil
+}
+
+func (icmpProber) Probe(ctx context.Context, req HttpRequest) ProbeResult {
+	return probeICMP(ctx, req)
+}
+
+type pingSocket struct {
+	conn     *icmp.PacketConn
+	proto    int
+	echo     icmp.Type
+	reply    icmp.Type
+	datagram bool
+}
+
+// listenICMP prefers Linux unprivileged ping sockets (net.ipv4.ping_group_range)
+// and falls back to raw sockets when running privileged.
+func listenICMP(ip net.IP) (*pingSocket, error) {
+	if ip.To4() != nil {
+		if conn, err := icmp.ListenPacket("udp4", "0.0.0.0"); err == nil {
+			return &pingSocket{conn: conn, proto: 1, echo: ipv4.ICMPTypeEcho, reply: ipv4.ICMPTypeEchoReply, datagram: true}, nil
+		}
+		conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+		if err != nil {
+			return nil, fmt.Errorf("no ICMP socket available (enable net.ipv4.ping_group_range or run privileged): %w", err)
+		}
+		return &pingSocket{conn: conn, proto: 1, echo: ipv4.ICMPTypeEcho, reply: ipv4.ICMPTypeEchoReply}, nil
+	}
+
+	if conn, err := icmp.ListenPacket("udp6", "::"); err == nil {
+		return &pingSocket{conn: conn, proto: 58, echo: ipv6.ICMPTypeEchoRequest, reply: ipv6.ICMPTypeEchoReply, datagram: true}, nil
+	}
+	conn, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return nil, fmt.Errorf("no ICMPv6 socket available (enable net.ipv4.ping_group_range or run privileged): %w", err)
+	}
+	return &pingSocket{conn: conn, proto: 58, echo: ipv6.ICMPTypeEchoRequest, reply: ipv6.ICMPTypeEchoReply}, nil
+}
+
+func (s *pingSocket) addr(ip net.IP) net.Addr {
+	if s.datagram {
+		return &net.UDPAddr{IP: ip}
+	}
+	return &net.IPAddr{IP: ip}
+}
+
+func probeICMP(ctx context.Context, req HttpRequest) ProbeResult {
+	opts, _ := req.Options.(*icmpOptions)
+	if opts == nil {
+		opts = &icmpOptions{Count: defaultPingCount, Size: defaultPingSize, interval: defaultPingInterval, timeout: defaultPingTimeout}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, req.Host)
+	if err != nil {
+		return newProbeResult(req, hr.Down, fmt.Sprintf("DNS error: %s", err.Error()))
+	}
+	ip := addrs[0].IP
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			ip = a.IP
+			break
+		}
+	}
+
+	sock, err := listenICMP(ip)
+	if err != nil {
+		return newProbeResult(req, hr.Down, err.Error())
+	}
+	defer sock.conn.Close()
+
+	// A random token in every payload tells our replies apart from other
+	// probes sharing the raw socket; the kernel rewrites the id on datagram
+	// sockets so it cannot be used for that.
+	token := make([]byte, 8)
+	_, _ = rand.Read(token)
+	id := os.Getpid() & 0xffff
+
+	rtts := make([]time.Duration, 0, opts.Count)
+	buf := make([]byte, 1500)
+	transmitted := 0
+
+	for seq := range opts.Count {
+		if seq > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(opts.interval):
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		payload := make([]byte, opts.Size)
+		copy(payload, token)
+
+		msg := icmp.Message{
+			Type: sock.echo,
+			Body: &icmp.Echo{ID: id, Seq: seq, Data: payload},
+		}
+		wire, err := msg.Marshal(nil)
+		if err != nil {
+			return newProbeResult(req, hr.Down, err.Error())
+		}
+
+		sentAt := time.Now()
+		if _, err := sock.conn.WriteTo(wire, sock.addr(ip)); err != nil {
+			return newProbeResult(req, hr.Down, fmt.Sprintf("send failed: %s", err.Error()))
+		}
+		transmitted++
+
+		_ = sock.conn.SetReadDeadline(sentAt.Add(opts.timeout))
+		for {
+			n, _, err := sock.conn.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+			reply, err := icmp.ParseMessage(sock.proto, buf[:n])
+			if err != nil || reply.Type != sock.reply {
+				continue
+			}
+			echo, ok := reply.Body.(*icmp.Echo)
+			if !ok || echo.Seq != seq || !bytes.HasPrefix(echo.Data, token) {
+				continue
+			}
+			if !sock.datagram && echo.ID != id {
+				continue
+			}
+			rtts = append(rtts, time.Since(sentAt))
+			break
+		}
+	}
+
+	stats := pingStats(transmitted, rtts)
+	state := hr.Up
+	description := fmt.Sprintf("%s: %d/%d replies, %.1f%% loss, avg %.3fms", ip, len(rtts), transmitted, stats["packet_loss"], stats["rtt_avg"])
+	switch {
+	case len(rtts) == 0:
+		state = hr.Down
+		description = fmt.Sprintf("%s: no echo replies, 100%% loss", ip)
+	case len(rtts) < transmitted:
+		state = hr.Warn
+	}
+
+	res := newProbeResult(req, state, description)
+	res.Metrics = stats
+	res.ResponseTime = stats["rtt_avg"]
+	return res
+}
+
+// pingStats reports loss in percent and RTTs in milliseconds. Jitter is the
+// mean difference between consecutive round trips.
+func pingStats(sent int, rtts []time.Duration) map[string]float64 {
+	stats := map[string]float64{
+		"packets_sent":     float64(sent),
+		"packets_received": float64(len(rtts)),
+		"packet_loss":      100,
+	}
+	if sent > 0 {
+		stats["packet_loss"] = math.Round(100*float64(sent-len(rtts))/float64(sent)*100) / 100
+	}
+	if len(rtts) == 0 {
+		return stats
+	}
+
+	lo, hi, sum := math.MaxFloat64, 0.0, 0.0
+	jitter := 0.0
+	for i, d := range rtts {
+		ms := millis(d)
+		lo, hi, sum = min(lo, ms), max(hi, ms), sum+ms
+		if i > 0 {
+			jitter += math.Abs(ms - millis(rtts[i-1]))
+		}
+	}
+
+	stats["rtt_min"] = lo
+	stats["rtt_max"] = hi
+	stats["rtt_avg"] = math.Round(sum/float64(len(rtts))*1000) / 1000
+	if len(rtts) > 1 {
+		stats["jitter"] = math.Round(jitter/float64(len(rtts)-1)*1000) / 1000
+	} else {
+		stats["jitter"] = 0
+	}
+	return stats
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"slices"
+	"strings"
+	"time"
+)
+
+// -------------------- MAIL PROBES --------------------
+
+type mailOptions struct {
+	StartTLS   bool   `json:"startTLS"`
+	ServerName string `json:"serverName"`
+	Helo       string `json:"helo"`
+
+	addr     string
+	implicit bool
+	dialect  *mailDialect
+}
+
+// mailDialect is one mail protocol's way of greeting, listing capabilities,
+// upgrading to TLS and logging in.
+type mailDialect struct {
+	port        string
+	tlsPort     string
+	startTLSCap string
+	greet       func(*mailSession) (string, error)
+	hello       func(*mailSession) ([]string, error)
+	startTLS    func(*mailSession) error
+	login       func(s *mailSession, caps []string, username, password string) error
+	quit        func(*mailSession)
+}
+
+var mailDialects = map[string]*mailDialect{
+	"smtp": {
+		port: "25", tlsPort: "465", startTLSCap: "STARTTLS",
+		greet: smtpGreet, hello: smtpHello, startTLS: smtpStartTLS, login: smtpLogin, quit: smtpQuit,
+	},
+	"imap": {
+		port: "143", tlsPort: "993", startTLSCap: "STARTTLS",
+		greet: imapGreet, hello: imapHello, startTLS: imapStartTLS, login: imapLogin, quit: imapQuit,
+	},
+	"pop3": {
+		port: "110", tlsPort: "995", startTLSCap: "STLS",
+		greet: popGreet, hello: popHello, startTLS: popStartTLS, login: popLogin, quit: popQuit,
+	},
+}
+
+type mailProber struct{}
+
+func init() {
+	RegisterProber(mailProber{}, "smtp", "smtps", "imap", "imaps", "pop3", "pop3s")
+}
+
+func (mailProber) Prepare(req *HttpRequest) error {
+	protocol := normalizeProtocol(req.Protocol)
+	base := strings.TrimSuffix(protocol, "s")
+	dialect, ok := mailDialects[base]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedProtocol, protocol)
+	}
+
+	opts := &mailOptions{implicit: base != protocol, dialect: dialect}
+	if err := decodeConfig(req, opts); err != nil {
+		return err
+	}
+
+	if opts.implicit && opts.StartTLS {
+		return fmt.Errorf("%s already uses TLS, startTLS applies to %s", protocol, base)
+	}
+
+	switch req.authType() {
+	case "":
+		if req.AuthType != "" {
+			return fmt.Errorf("unsupported auth type %q", req.AuthType)
+		}
+	case AuthBasic:
+		if req.Username == "" {
+			return errors.New("basic auth requires a username")
+		}
+		if !opts.implicit && !opts.StartTLS {
+			return fmt.Errorf("refusing to send credentials in clear text, use %ss or startTLS", base)
+		}
+	default:
+		return fmt.Errorf("%s auth is not supported for mail monitors", req.authType())
+	}
+
+	opts.addr = withDefaultPort(req.Host, ternary(opts.implicit, dialect.tlsPort, dialect.port))
+	host, _, err := net.SplitHostPort(opts.addr)
+	if err != nil {
+		return err
+	}
+	if opts.ServerName == "" {
+		opts.ServerName = host
+	}
+	if opts.Helo == "" {
+		opts.Helo = "localhost"
+	}
+
+	req.Options = opts
+	return nil
+}
+
+func (mailProber) Probe(ctx context.Context, req HttpRequest) ProbeResult {
+	return probeMail(ctx, req)
+}
+
+type mailSession struct {
+	conn net.Conn
+	tp   *textproto.Conn
+	helo string
+	tag  int
+	// secure is set once the session runs over TLS.
+	secure bool
+}
+
+func (s *mailSession) upgrade(ctx context.Context, cfg *tls.Config) error {
+	tlsConn := tls.Client(s.conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+	s.conn = tlsConn
+	s.tp = textproto.NewConn(tlsConn)
+	s.secure = true
+	return nil
+}
+
+// probeMail reads the banner, lists capabilities and optionally upgrades to
+// TLS and logs in. Any step the server refuses makes the monitor down.
+func probeMail(ctx context.Context, req HttpRequest) ProbeResult {
+	opts, _ := req.Options.(*mailOptions)
+	if opts == nil {
+		return newProbeResult(req, hr.Down, "mail monitor was not prepared")
+	}
+	dialect := opts.dialect
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	start := time.Now()
+	timings := &Timings{}
+	var tlsInfo TLSInfo
+	tlsConfig := tlsProbeConfig(opts.ServerName, &tlsInfo)
+
+	fail := func(step string, err error) ProbeResult {
+		timings.Total = millis(time.Since(start))
+		res := newProbeResult(req, hr.Down, fmt.Sprintf("%s - %s failed: %s", req.Host, step, mailError(err)))
+		res.Timings = timings
+		if len(tlsInfo.Chain) > 0 {
+			res.TLS = &tlsInfo
+		}
+		return res
+	}
+
+	dialer := net.Dialer{Timeout: defaultTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", opts.addr)
+	if err != nil {
+		return newProbeResult(req, hr.Down, err.Error())
+	}
+	defer conn.Close()
+	timings.Connect = millis(time.Since(start))
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	s := &mailSession{conn: conn, tp: textproto.NewConn(conn), helo: opts.Helo}
+
+	if opts.implicit {
+		tlsStart := time.Now()
+		err := s.upgrade(ctx, tlsConfig)
+		timings.TLS = millis(time.Since(tlsStart))
+		if err != nil {
+			return fail("tls handshake", err)
+		}
+	}
+
+	banner, err := dialect.greet(s)
+	timings.TTFB = millis(time.Since(start))
+	if err != nil {
+		return fail("greeting", err)
+	}
+
+	caps, err := dialect.hello(s)
+	if err != nil {
+		return fail("capabilities", err)
+	}
+
+	if opts.StartTLS {
+		if !hasCapability(caps, dialect.startTLSCap) {
+			return fail("starttls", fmt.Errorf("server does not advertise %s", dialect.startTLSCap))
+		}
+		tlsStart := time.Now()
+		if err := dialect.startTLS(s); err != nil {
+			return fail("starttls", err)
+		}
+		err := s.upgrade(ctx, tlsConfig)
+		timings.TLS = millis(time.Since(tlsStart))
+		if err != nil {
+			return fail("tls handshake", err)
+		}
+		if caps, err = dialect.hello(s); err != nil {
+			return fail("capabilities", err)
+		}
+	}
+
+	description := fmt.Sprintf("%s - %s", req.Host, truncate(banner, 120))
+	if req.Username != "" {
+		// Prepare requires TLS already; this keeps it that way.
+		if !s.secure {
+			return fail("login", errors.New("refusing to send credentials in clear text"))
+		}
+		if err := dialect.login(s, caps, req.Username, req.Password); err != nil {
+			return fail("login", err)
+		}
+		description += " - login ok"
+	}
+
+	dialect.quit(s)
+	timings.Total = millis(time.Since(start))
+
+	state, description := applyCertExpiry(req, &tlsInfo, hr.Up, description)
+
+	res := newProbeResult(req, state, description)
+	res.Timings = timings
+	res.ResponseTime = timings.Total
+	res.Details = map[string]string{
+		"banner":       banner,
+		"capabilities": strings.Join(caps, " "),
+	}
+	if len(tlsInfo.Chain) > 0 {
+		res.TLS = &tlsInfo
+	}
+	return res
+}
+
+func hasCapability(caps []string, name string) bool {
+	return slices.ContainsFunc(caps, func(c string) bool {
+		word, _, _ := strings.Cut(c, " ")
+		return strings.EqualFold(word, name)
+	})
+}
+
+func mailError(err error) string {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return fmt.Sprintf("%d %s", protoErr.Code, strings.ReplaceAll(protoErr.Msg, "\n", " "))
+	}
+	return err.Error()
+}
+
+// -------------------- SMTP --------------------
+
+func smtpGreet(s *mailSession) (string, error) {
+	code, msg, err := s.tp.ReadResponse(220)
+	if err != nil {
+		return "", err
+	}
+	first, _, _ := strings.Cut(msg, "\n")
+	return fmt.Sprintf("%d %s", code, first), nil
+}
+
+func smtpHello(s *mailSession) ([]string, error) {
+	if err := s.tp.PrintfLine("EHLO %s", s.helo); err != nil {
+		return nil, err
+	}
+	_, msg, err := s.tp.ReadResponse(250)
+	if err == nil {
+		// The first line greets us back, the rest are extensions.
+		lines := strings.Split(msg, "\n")
+		return lines[1:], nil
+	}
+
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) {
+		return nil, err
+	}
+	if err := s.tp.PrintfLine("HELO %s", s.helo); err != nil {
+		return nil, err
+	}
+	if _, _, err := s.tp.ReadResponse(250); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func smtpStartTLS(s *mailSession) error {
+	if err := s.tp.PrintfLine("STARTTLS"); err != nil {
+		return err
+	}
+	_, _, err := s.tp.ReadResponse(220)
+	return err
+}
+
+func smtpLogin(s *mailSession, caps []string, username, password string) error {
+	var mechanisms []string
+	for _, c := range caps {
+		if word, rest, _ := strings.Cut(c, " "); strings.EqualFold(word, "AUTH") {
+			mechanisms = strings.Fields(strings.ToUpper(rest))
+		}
+	}
+	if mechanisms == nil {
+		return errors.New("server does not advertise AUTH")
+	}
+
+	switch {
+	case slices.Contains(mechanisms, "PLAIN"):
+		token := base64.StdEncoding.EncodeToString([]byte("\x00" + username + "\x00" + password))
+		if err := s.tp.PrintfLine("AUTH PLAIN %s", token); err != nil {
+			return err
+		}
+
+	case slices.Contains(mechanisms, "LOGIN"):
+		if err := s.tp.PrintfLine("AUTH LOGIN"); err != nil {
+			return err
+		}
+		for _, answer := range []string{username, password} {
+			if _, _, err := s.tp.ReadResponse(334); err != nil {
+				return err
+			}
+			if err := s.tp.PrintfLine("%s", base64.StdEncoding.EncodeToString([]byte(answer))); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("no supported AUTH mechanism (server offers %s)", strings.Join(mechanisms, " "))
+	}
+
+	_, _, err := s.tp.ReadResponse(235)
+	return err
+}
+
+func smtpQuit(s *mailSession) {
+	if s.tp.PrintfLine("QUIT") == nil {
+		_, _, _ = s.tp.ReadResponse(221)
+	}
+}
+
+// -------------------- IMAP --------------------
+
+func imapGreet(s *mailSession) (string, error) {
+	line, err := s.tp.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "* OK") && !strings.HasPrefix(line, "* PREAUTH") {
+		return "", fmt.Errorf("unexpected greeting %q", truncate(line, 120))
+	}
+	return line, nil
+}
+
+// imapCmd sends a tagged command and returns the untagged lines before the
+// tagged completion, failing unless it is OK.
+func (s *mailSession) imapCmd(command string, args ...any) ([]string, error) {
+	s.tag++
+	tag := fmt.Sprintf("a%d", s.tag)
+	if err := s.tp.PrintfLine("%s "+command, append([]any{tag}, args...)...); err != nil {
+		return nil, err
+	}
+
+	var untagged []string
+	for {
+		line, err := s.tp.ReadLine()
+		if err != nil {
+			return untagged, err
+		}
+		rest, ok := strings.CutPrefix(line, tag+" ")
+		if !ok {
+			untagged = append(untagged, line)
+			continue
+		}
+		if status, text, _ := strings.Cut(rest, " "); !strings.EqualFold(status, "OK") {
+			return untagged, fmt.Errorf("%s %s", strings.ToUpper(status), text)
+		}
+		return untagged, nil
+	}
+}
+
+func imapHello(s *mailSession) ([]string, error) {
+	lines, err := s.imapCmd("CAPABILITY")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		if rest, ok := strings.CutPrefix(line, "* CAPABILITY "); ok {
+			return strings.Fields(rest), nil
+		}
+	}
+	return nil, nil
+}
+
+func imapStartTLS(s *mailSession) error {
+	_, err := s.imapCmd("STARTTLS")
+	return err
+}
+
+func imapLogin(s *mailSession, _ []string, username, password string) error {
+	_, err := s.imapCmd("LOGIN %s %s", imapQuote(username), imapQuote(password))
+	return err
+}
+
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func imapQuit(s *mailSession) {
+	_, _ = s.imapCmd("LOGOUT")
+}
+
+// -------------------- POP3 --------------------
+
+func (s *mailSession) popResponse() (string, error) {
+	line, err := s.tp.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if rest, ok := strings.CutPrefix(line, "+OK"); ok {
+		return strings.TrimSpace(rest), nil
+	}
+	return "", errors.New(truncate(line, 120))
+}
+
+func (s *mailSession) popCmd(command string, args ...any) (string, error) {
+	if err := s.tp.PrintfLine(command, args...); err != nil {
+		return "", err
+	}
+	return s.popResponse()
+}
+
+func popGreet(s *mailSession) (string, error) {
+	msg, err := s.popResponse()
+	if err != nil {
+		return "", err
+	}
+	return "+OK " + msg, nil
+}
+
+func popHello(s *mailSession) ([]string, error) {
+	if _, err := s.popCmd("CAPA"); err != nil {
+		// CAPA is optional (RFC 2449); old servers simply lack it.
+		return nil, nil
+	}
+	return s.tp.ReadDotLines()
+}
+
+func popStartTLS(s *mailSession) error {
+	_, err := s.popCmd("STLS")
+	return err
+}
+
+func popLogin(s *mailSession, _ []string, username, password string) error {
+	if _, err := s.popCmd("USER %s", username); err != nil {
+		return err
+	}
+	_, err := s.popCmd("PASS %s", password)
+	return err
+}
+
+func popQuit(s *mailSession) {
+	_, _ = s.popCmd("QUIT")
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// -------------------- SSH PROBE --------------------
+
+const minRSABits = 2048
+
+// weakSSHAlgorithms are offered by servers still accepting SHA-1 key
+// exchange, CBC/RC4 ciphers, MD5 MACs or DSA host keys.
+var weakSSHAlgorithms = []string{
+	"diffie-hellman-group1-sha1",
+	"diffie-hellman-group14-sha1",
+	"diffie-hellman-group-exchange-sha1",
+	"ssh-dss",
+	"3des-cbc",
+	"aes128-cbc",
+	"aes192-cbc",
+	"aes256-cbc",
+	"blowfish-cbc",
+	"cast128-cbc",
+	"arcfour",
+	"arcfour128",
+	"arcfour256",
+	"hmac-md5",
+	"hmac-md5-96",
+	"hmac-sha1-96",
+}
+
+type sshOptions struct {
+	Fingerprint      string `json:"fingerprint"`
+	HostKeyAlgorithm string `json:"hostKeyAlgorithm"`
+
+	addr string
+}
+
+type sshProber struct{}
+
+func init() {
+	RegisterProber(sshProber{}, "ssh")
+}
+
+func (sshProber) Prepare(req *HttpRequest) error {
+	opts := &sshOptions{addr: withDefaultPort(req.Host, "22")}
+	if err := decodeConfig(req, opts); err != nil {
+		return err
+	}
+
+	if opts.Fingerprint != "" {
+		fingerprint, err := normalizeFingerprint(opts.Fingerprint)
+		if err != nil {
+			return err
+		}
+		opts.Fingerprint = fingerprint
+	}
+
+	req.Options = opts
+	return nil
+}
+
+func (sshProber) Probe(ctx context.Context, req HttpRequest) ProbeResult {
+	return probeSSH(ctx, req)
+}
+
+// normalizeFingerprint accepts the SHA256 form printed by ssh-keygen -l,
+// with or without its prefix and padding.
+func normalizeFingerprint(s string) (string, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "=")
+	if rest, ok := strings.CutPrefix(s, "SHA256:"); ok {
+		s = rest
+	}
+	if len(s) != 43 {
+		return "", errors.New("fingerprint must be a SHA256 fingerprint as printed by ssh-keygen -l")
+	}
+	return "SHA256:" + s, nil
+}
+
+// recordingConn keeps the first bytes the server sends, which hold its
+// identification string and the plaintext KEXINIT.
+type recordingConn struct {
+	net.Conn
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	if room := 32*1024 - len(c.buf); room > 0 {
+		c.buf = append(c.buf, p[:min(n, room)]...)
+	}
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *recordingConn) recorded() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.buf)
+}
+
+var errHostKeyCaptured = errors.New("host key captured")
+
+// probeSSH runs the key exchange until the server has proven its host key,
+// then hangs up before authenticating.
+func probeSSH(ctx context.Context, req HttpRequest) ProbeResult {
+	opts, _ := req.Options.(*sshOptions)
+	if opts == nil {
+		opts = &sshOptions{addr: withDefaultPort(req.Host, "22")}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	start := time.Now()
+	timings := &Timings{}
+
+	dialer := net.Dialer{Timeout: defaultTimeout}
+	raw, err := dialer.DialContext(ctx, "tcp", opts.addr)
+	if err != nil {
+		return newProbeResult(req, hr.Down, err.Error())
+	}
+	defer raw.Close()
+	timings.Connect = millis(time.Since(start))
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = raw.SetDeadline(deadline)
+	}
+
+	conn := &recordingConn{Conn: raw}
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User: "probe",
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errHostKeyCaptured
+		},
+		Timeout: defaultTimeout,
+	}
+	if opts.HostKeyAlgorithm != "" {
+		config.HostKeyAlgorithms = []string{opts.HostKeyAlgorithm}
+	}
+
+	_, _, _, err = ssh.NewClientConn(conn, opts.addr, config)
+	timings.Total = millis(time.Since(start))
+
+	identification, kexInit := parseSSHPreamble(conn.recorded())
+
+	if hostKey == nil {
+		description := fmt.Sprintf("%s - key exchange failed: %s", req.Host, err.Error())
+		if identification == "" {
+			description = fmt.Sprintf("%s - no SSH identification: %s", req.Host, err.Error())
+		}
+		res := newProbeResult(req, hr.Down, description)
+		res.Timings = timings
+		return res
+	}
+
+	fingerprint := ssh.FingerprintSHA256(hostKey)
+	details := map[string]string{
+		"identification": identification,
+		"host_key_type":  hostKey.Type(),
+		"fingerprint":    fingerprint,
+	}
+
+	state := hr.Up
+	description := fmt.Sprintf("%s - %s - %s %s", req.Host, identification, hostKey.Type(), fingerprint)
+
+	weak := weakSSH(identification, hostKey, kexInit)
+	if len(weak) > 0 {
+		state = hr.Warn
+		details["weak_algorithms"] = strings.Join(weak, ", ")
+		description = fmt.Sprintf("%s - weak: %s", description, details["weak_algorithms"])
+	}
+
+	if opts.Fingerprint != "" && fingerprint != opts.Fingerprint {
+		state = hr.Down
+		description = fmt.Sprintf("%s - host key %s does not match pinned %s", req.Host, fingerprint, opts.Fingerprint)
+	}
+
+	res := newProbeResult(req, state, description)
+	res.Timings = timings
+	res.ResponseTime = timings.Total
+	res.Details = details
+	return res
+}
+
+// parseSSHPreamble finds the server identification line (RFC 4253 4.2) and
+// the algorithm name-lists of the KEXINIT packet that follows it.
+func parseSSHPreamble(data []byte) (string, [][]string) {
+	identification := ""
+	for len(data) > 0 {
+		line, rest, found := bytes.Cut(data, []byte("\n"))
+		if !found {
+			return identification, nil
+		}
+		data = rest
+		if bytes.HasPrefix(line, []byte("SSH-")) {
+			identification = string(bytes.TrimRight(line, "\r"))
+			break
+		}
+	}
+
+	// uint32 packet_length, byte padding_length, then the payload:
+	// byte SSH_MSG_KEXINIT, 16 byte cookie and ten name-lists.
+	if len(data) < 5+1+16 {
+		return identification, nil
+	}
+	length := binary.BigEndian.Uint32(data)
+	if length < 1+1+16 || int(length) > len(data)-4 || data[5] != 20 {
+		return identification, nil
+	}
+	payload := data[6+16 : 4+length]
+
+	var lists [][]string
+	for range 10 {
+		if len(payload) < 4 {
+			break
+		}
+		n := binary.BigEndian.Uint32(payload)
+		if int(n) > len(payload)-4 {
+			break
+		}
+		lists = append(lists, strings.Split(string(payload[4:4+n]), ","))
+		payload = payload[4+n:]
+	}
+	return identification, lists
+}
+
+func weakSSH(identification string, key ssh.PublicKey, kexInit [][]string) []string {
+	var weak []string
+	if strings.HasPrefix(identification, "SSH-1.") {
+		weak = append(weak, "protocol "+strings.Fields(identification)[0])
+	}
+
+	if cryptoKey, ok := key.(ssh.CryptoPublicKey); ok {
+		if rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey); ok && rsaKey.N.BitLen() < minRSABits {
+			weak = append(weak, fmt.Sprintf("ssh-rsa %d bits", rsaKey.N.BitLen()))
+		}
+	}
+
+	for _, list := range kexInit {
+		for _, name := range list {
+			if slices.Contains(weakSSHAlgorithms, name) && !slices.Contains(weak, name) {
+				weak = append(weak, name)
+			}
+		}
+	}
+	return weak
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// -------------------- TCP PROBE --------------------
+
+const (
+	ExpectPrefix   = "prefix"
+	ExpectContains = "contains"
+	ExpectRegex    = "regex"
+
+	defaultReadTimeout = 5 * time.Second
+	maxExpectSize      = 4096
+)
+
+type tcpOptions struct {
+	Send        string `json:"send"`
+	Expect      string `json:"expect"`
+	ExpectMode  string `json:"expectMode"`
+	ReadTimeout int64  `json:"readTimeout"`
+	TLS         bool   `json:"tls"`
+	ServerName  string `json:"serverName"`
+
+	expect      *expectation
+	readTimeout time.Duration
+}
+
+type tcpProber struct{}
+
+func init() {
+	RegisterProber(tcpProber{}, "tcp")
+}
+
+func (tcpProber) Prepare(req *HttpRequest) error {
+	host, _, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		return fmt.Errorf("tcp host must be host:port: %w", err)
+	}
+
+	opts := &tcpOptions{}
+	if err := decodeConfig(req, opts); err != nil {
+		return err
+	}
+
+	if opts.expect, err = newExpectation(opts.Expect, opts.ExpectMode); err != nil {
+		return err
+	}
+
+	opts.readTimeout = defaultReadTimeout
+	if opts.ReadTimeout > 0 {
+		opts.readTimeout = time.Duration(opts.ReadTimeout) * time.Millisecond
+	}
+
+	if opts.ServerName == "" {
+		opts.ServerName = host
+	}
+
+	req.Options = opts
+	return nil
+}
+
+func (tcpProber) Probe(ctx context.Context, req HttpRequest) ProbeResult {
+	return probeTCP(ctx, req)
+}
+
+func probeTCP(ctx context.Context, req HttpRequest) ProbeResult {
+	opts, _ := req.Options.(*tcpOptions)
+	if opts == nil {
+		opts = &tcpOptions{readTimeout: defaultReadTimeout}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	start := time.Now()
+	timings := &Timings{}
+
+	dialer := net.Dialer{Timeout: defaultTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", req.Host)
+	if err != nil {
+		return newProbeResult(req, hr.Down, err.Error())
+	}
+	defer conn.Close()
+	timings.Connect = millis(time.Since(start))
+
+	var tlsInfo TLSInfo
+	if opts.TLS {
+		tlsStart := time.Now()
+		tlsConn := tls.Client(conn, tlsProbeConfig(opts.ServerName, &tlsInfo))
+		err := tlsConn.HandshakeContext(ctx)
+		timings.TLS = millis(time.Since(tlsStart))
+		if err != nil {
+			res := newProbeResult(req, hr.Down, "tls handshake failed: "+err.Error())
+			if len(tlsInfo.Chain) > 0 {
+				res.TLS = &tlsInfo
+			}
+			res.Timings = timings
+			return res
+		}
+		conn = tlsConn
+	}
+
+	if opts.Send != "" {
+		_ = conn.SetWriteDeadline(time.Now().Add(opts.readTimeout))
+		if _, err := conn.Write([]byte(opts.Send)); err != nil {
+			res := newProbeResult(req, hr.Down, "write failed: "+err.Error())
+			res.Timings = timings
+			return res
+		}
+	}
+
+	state, description := hr.Up, "connected"
+	if opts.expect != nil {
+		firstByte := time.Time{}
+		got, err := opts.expect.read(conn, opts.readTimeout, &firstByte)
+		if !firstByte.IsZero() {
+			timings.TTFB = millis(firstByte.Sub(start))
+		}
+		switch {
+		case err == nil:
+			description = fmt.Sprintf("response received %s", truncate(strings.TrimSpace(string(got)), 120))
+		case len(got) == 0:
+			state, description = hr.Down, fmt.Sprintf("no response after connect: %s", err.Error())
+		default:
+			state, description = hr.Down, fmt.Sprintf("unexpected response %q: %s", truncate(string(got), 120), err.Error())
+		}
+	}
+
+	timings.Total = millis(time.Since(start))
+	state, description = applyCertExpiry(req, &tlsInfo, state, description)
+
+	res := newProbeResult(req, state, description)
+	res.Timings = timings
+	res.ResponseTime = timings.Total
+	if opts.TLS {
+		res.TLS = &tlsInfo
+	}
+	return res
+}
+
+// -------------------- EXPECTATIONS --------------------
+
+// expectation matches the start of a stream response, shared by the
+// connection oriented probes.
+type expectation struct {
+	mode   string
+	text   string
+	regexp *regexp.Regexp
+}
+
+var errExpectationNotMet = errors.New("expectation not met")
+
+func newExpectation(text, mode string) (*expectation, error) {
+	if text == "" {
+		return nil, nil
+	}
+
+	e := &expectation{mode: strings.ToLower(mode), text: text}
+	switch e.mode {
+	case "":
+		e.mode = ExpectPrefix
+	case ExpectPrefix, ExpectContains:
+	case ExpectRegex:
+		re, err := regexp.Compile(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expect regex: %w", err)
+		}
+		e.regexp = re
+	default:
+		return nil, fmt.Errorf("unsupported expect mode %q", mode)
+	}
+	return e, nil
+}
+
+func (e *expectation) match(data []byte) bool {
+	switch e.mode {
+	case ExpectContains:
+		return bytes.Contains(data, []byte(e.text))
+	case ExpectRegex:
+		return e.regexp.Match(data)
+	}
+	return bytes.HasPrefix(data, []byte(e.text))
+}
+
+// read consumes conn until the expectation matches, the peer stops sending
+// or timeout passes, returning everything read so far.
+func (e *expectation) read(conn net.Conn, timeout time.Duration, firstByte *time.Time) ([]byte, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+
+	buf := make([]byte, 0, 512)
+	chunk := make([]byte, 512)
+	for len(buf) < maxExpectSize {
+		n, err := conn.Read(chunk)
+		if n > 0 {
+			if firstByte != nil && firstByte.IsZero() {
+				*firstByte = time.Now()
+			}
+			buf = append(buf, chunk[:n]...)
+			if e.match(buf) {
+				return buf, nil
+			}
+			if e.mode == ExpectPrefix && !bytes.HasPrefix([]byte(e.text), buf) {
+				return buf, errExpectationNotMet
+			}
+		}
+		if err != nil {
+			return buf, err
+		}
+	}
+	return buf, errExpectationNotMet
+}
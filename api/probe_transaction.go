@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptrace"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// -------------------- TRANSACTION PROBE --------------------
+
+// A transaction monitor runs ordered HTTP steps that share a cookie jar.
+// Steps can extract values from their response and later steps use them as
+// {{name}} in the URL, headers and body. {{username}} and {{password}} come
+// from the monitor's credentials so secrets stay out of the config. Relative
+// step URLs resolve against the monitor's host, https unless it names
+// another scheme. Extracted values are often session tokens, so they are
+// redacted from the result like the credentials.
+
+const maxTransactionSteps = 20
+
+var templateVar = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+type transactionOptions struct {
+	Steps []TransactionStep `json:"steps"`
+}
+
+type TransactionStep struct {
+	Name        string            `json:"name"`
+	Method      string            `json:"method"`
+	URL         string            `json:"url"`
+	Headers     map[string]string `json:"headers"`
+	Body        string            `json:"body"`
+	ContentType string            `json:"contentType"`
+	Assertions  []Assertion       `json:"assertions"`
+	Extract     []Extraction      `json:"extract"`
+}
+
+// Extraction reads a value from a step's response into a variable. Regex, if
+// set, is applied to the value and its first group (or whole match) is kept;
+// body extractions require it.
+type Extraction struct {
+	Var      string `json:"var"`
+	Source   string `json:"source"`
+	Property string `json:"property"`
+	Regex    string `json:"regex"`
+
+	regexp *regexp.Regexp
+}
+
+type StepResult struct {
+	Name        string            `json:"name"`
+	URL         string            `json:"url"`
+	Status      int               `json:"status,omitempty"`
+	State       string            `json:"state"`
+	Description string            `json:"description,omitempty"`
+	Assertions  []AssertionResult `json:"assertions,omitempty"`
+	Timings     *Timings          `json:"timings,omitempty"`
+	TLS         *TLSInfo          `json:"tls,omitempty"`
+}
+
+type transactionProber struct{}
+
+func init() {
+	RegisterProber(transactionProber{}, "transaction")
+}
+
+func (transactionProber) Prepare(req *HttpRequest) error {
+	opts := &transactionOptions{}
+	if err := decodeConfig(req, opts); err != nil {
+		return err
+	}
+
+	if len(opts.Steps) == 0 {
+		return errors.New("transaction needs at least one step")
+	}
+	if len(opts.Steps) > maxTransactionSteps {
+		return fmt.Errorf("transaction has %d steps, at most %d allowed", len(opts.Steps), maxTransactionSteps)
+	}
+	if _, err := transactionBase(req.Host); err != nil {
+		return fmt.Errorf("invalid host: %w", err)
+	}
+
+	defined := []string{"username", "password"}
+	for i := range opts.Steps {
+		step := &opts.Steps[i]
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step %d", i+1)
+		}
+
+		step.Method = strings.ToUpper(strings.TrimSpace(step.Method))
+		switch step.Method {
+		case "":
+			step.Method = MethodGet
+		case MethodGet, MethodHead, MethodPost, MethodPut, MethodPatch, MethodDelete, MethodOptions:
+		default:
+			return fmt.Errorf("%s: unsupported HTTP method %q", step.Name, step.Method)
+		}
+
+		if step.URL == "" {
+			return fmt.Errorf("%s: url is required", step.Name)
+		}
+		if err := validateAssertions(step.Assertions); err != nil {
+			return fmt.Errorf("%s: %w", step.Name, err)
+		}
+
+		templates := []string{step.URL, step.Body}
+		for _, value := range step.Headers {
+			templates = append(templates, value)
+		}
+		for _, t := range templates {
+			for _, m := range templateVar.FindAllStringSubmatch(t, -1) {
+				if !slices.Contains(defined, m[1]) {
+					return fmt.Errorf("%s: variable %q is not extracted by an earlier step", step.Name, m[1])
+				}
+			}
+		}
+
+		for j := range step.Extract {
+			e := &step.Extract[j]
+			if !templateVar.MatchString("{{" + e.Var + "}}") {
+				return fmt.Errorf("%s: invalid variable name %q", step.Name, e.Var)
+			}
+			switch e.Source {
+			case AssertJSON, AssertHeader:
+				if strings.TrimSpace(e.Property) == "" {
+					return fmt.Errorf("%s: %s extraction of %q needs a property", step.Name, e.Source, e.Var)
+				}
+			case AssertBody:
+				if e.Regex == "" {
+					return fmt.Errorf("%s: body extraction of %q needs a regex", step.Name, e.Var)
+				}
+			default:
+				return fmt.Errorf("%s: unknown extraction source %q", step.Name, e.Source)
+			}
+			if e.Regex != "" {
+				re, err := regexp.Compile(e.Regex)
+				if err != nil {
+					return fmt.Errorf("%s: extraction of %q: %w", step.Name, e.Var, err)
+				}
+				e.regexp = re
+			}
+			defined = append(defined, e.Var)
+		}
+	}
+
+	req.Options = opts
+	return nil
+}
+
+func (transactionProber) Probe(ctx context.Context, req HttpRequest) ProbeResult {
+	return probeTransaction(ctx, req)
+}
+
+// probeTransaction runs the steps in order and stops at the first one that
+// fails, since later steps depend on it.
+func probeTransaction(ctx context.Context, req HttpRequest) ProbeResult {
+	opts, _ := req.Options.(*transactionOptions)
+	if opts == nil {
+		return newProbeResult(req, hr.Down, "transaction monitor was not prepared")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	jar, _ := cookiejar.New(nil)
+	base, _ := transactionBase(req.Host)
+	vars := map[string]string{"username": req.Username, "password": req.Password}
+
+	state := hr.Up
+	description := fmt.Sprintf("%d steps passed", len(opts.Steps))
+	steps := make([]StepResult, 0, len(opts.Steps))
+	total := 0.0
+
+	for i, step := range opts.Steps {
+		result := runStep(ctx, req, step, base, jar, vars)
+		steps = append(steps, result)
+		if result.Timings != nil {
+			total += result.Timings.Total
+		}
+
+		if result.State == hr.Up {
+			continue
+		}
+		if state == hr.Up || result.State == hr.Down {
+			state = result.State
+			description = fmt.Sprintf("step %d/%d %s - %s", i+1, len(opts.Steps), step.Name, result.Description)
+		}
+		if result.State == hr.Down {
+			break
+		}
+	}
+
+	extracted := []string{}
+	for name, value := range vars {
+		if name != "username" && name != "password" {
+			extracted = append(extracted, value)
+		}
+	}
+
+	res := newProbeResult(req, state, description)
+	res.Steps = steps
+	res.ResponseTime = total
+	return redactSecrets(res, extracted)
+}
+
+// transactionBase is the URL relative step URLs resolve against.
+func transactionBase(host string) (*url.URL, error) {
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	return url.Parse(host)
+}
+
+func runStep(ctx context.Context, req HttpRequest, step TransactionStep, base *url.URL, jar http.CookieJar, vars map[string]string) StepResult {
+	result := StepResult{Name: step.Name, URL: step.URL, State: hr.Down}
+
+	target, err := base.Parse(expandVars(step.URL, vars))
+	if err != nil {
+		result.Description = "invalid url: " + err.Error()
+		return result
+	}
+
+	timer := newPhaseTimer()
+	traced := httptrace.WithClientTrace(ctx, timer.trace())
+
+	var body io.Reader
+	if step.Body != "" {
+		body = strings.NewReader(expandVars(step.Body, vars))
+	}
+
+	r, err := http.NewRequestWithContext(traced, step.Method, target.String(), body)
+	if err != nil {
+		result.Description = err.Error()
+		return result
+	}
+
+	// Monitor-wide headers apply to every step; the step's own win.
+	headers := make(map[string]string, len(req.Headers)+len(step.Headers))
+	for key, value := range req.Headers {
+		headers[http.CanonicalHeaderKey(key)] = value
+	}
+	for key, value := range step.Headers {
+		headers[http.CanonicalHeaderKey(key)] = expandVars(value, vars)
+	}
+	for key, value := range headers {
+		if key == "Host" {
+			r.Host = value
+			continue
+		}
+		r.Header.Set(key, value)
+	}
+	if step.ContentType != "" {
+		r.Header.Set(HeaderContentType, step.ContentType)
+	}
+	switch {
+	case req.UserAgent != "":
+		r.Header.Set("User-Agent", req.UserAgent)
+	case r.Header.Get("User-Agent") == "" && userAgent != "":
+		r.Header.Set("User-Agent", userAgent)
+	}
+
+	var tlsInfo TLSInfo
+	client := probeClient(target.Hostname(), &tlsInfo)
+	client.Jar = jar
+
+	resp, err := client.Do(r)
+	if err != nil {
+		result.Description = err.Error()
+		result.Timings = timer.timings(time.Now())
+		if len(tlsInfo.Chain) > 0 {
+			result.TLS = &tlsInfo
+		}
+		return result
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	result.Timings = timer.timings(time.Now())
+	result.Status = resp.StatusCode
+	if len(tlsInfo.Chain) > 0 {
+		result.TLS = &tlsInfo
+	}
+	if err != nil {
+		result.Description = fmt.Sprintf("%d - read body failed: %s", resp.StatusCode, err.Error())
+		return result
+	}
+
+	result.Assertions = evaluateAssertions(step.Assertions, resp, respBody)
+	state, failures := assertionState(result.Assertions)
+	description := fmt.Sprintf("%d", resp.StatusCode)
+	if len(failures) > 0 {
+		description = fmt.Sprintf("%s - %s", description, strings.Join(failures, "; "))
+	}
+
+	if state != hr.Down {
+		for _, e := range step.Extract {
+			value, err := e.extract(resp, respBody)
+			if err != nil {
+				state = hr.Down
+				description = fmt.Sprintf("%s - extract %s: %s", description, e.Var, err.Error())
+				break
+			}
+			vars[e.Var] = value
+		}
+	}
+
+	result.State, result.Description = applyCertExpiry(req, &tlsInfo, state, description)
+	return result
+}
+
+func (e Extraction) extract(resp *http.Response, body []byte) (string, error) {
+	var value string
+	switch e.Source {
+	case AssertHeader:
+		value = resp.Header.Get(e.Property)
+		if value == "" {
+			return "", fmt.Errorf("header %s not found", e.Property)
+		}
+	case AssertJSON:
+		var doc any
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return "", errors.New("body is not valid JSON")
+		}
+		v, ok := lookupJSONPath(doc, e.Property)
+		if !ok {
+			return "", fmt.Errorf("%s not found", e.Property)
+		}
+		value = stringify(v)
+	default:
+		value = string(body)
+	}
+
+	if e.regexp == nil {
+		return value, nil
+	}
+	m := e.regexp.FindStringSubmatch(value)
+	switch {
+	case m == nil:
+		return "", fmt.Errorf("%s does not match", e.Regex)
+	case len(m) > 1:
+		return m[1], nil
+	}
+	return m[0], nil
+}
+
+func expandVars(s string, vars map[string]string) string {
+	return templateVar.ReplaceAllStringFunc(s, func(m string) string {
+		return vars[templateVar.FindStringSubmatch(m)[1]]
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// -------------------- UDP PROBE --------------------
+
+type udpOptions struct {
+	Send        string `json:"send"`
+	SendHex     string `json:"sendHex"`
+	Expect      string `json:"expect"`
+	ExpectMode  string `json:"expectMode"`
+	ReadTimeout int64  `json:"readTimeout"`
+
+	payload     []byte
+	expect      *expectation
+	readTimeout time.Duration
+}
+
+type udpProber struct{}
+
+func init() {
+	RegisterProber(udpProber{}, "udp")
+}
+
+func (udpProber) Prepare(req *HttpRequest) error {
+	if _, _, err := net.SplitHostPort(req.Host); err != nil {
+		return fmt.Errorf("udp host must be host:port: %w", err)
+	}
+
+	opts := &udpOptions{}
+	if err := decodeConfig(req, opts); err != nil {
+		return err
+	}
+
+	switch {
+	case opts.Send != "" && opts.SendHex != "":
+		return errors.New("set either send or sendHex, not both")
+	case opts.SendHex != "":
+		payload, err := hex.DecodeString(strings.ReplaceAll(opts.SendHex, " ", ""))
+		if err != nil {
+			return fmt.Errorf("invalid sendHex: %w", err)
+		}
+		opts.payload = payload
+	default:
+		opts.payload = []byte(opts.Send)
+	}
+
+	var err error
+	if opts.expect, err = newExpectation(opts.Expect, opts.ExpectMode); err != nil {
+		return err
+	}
+
+	opts.readTimeout = defaultReadTimeout
+	if opts.ReadTimeout > 0 {
+		opts.readTimeout = time.Duration(opts.ReadTimeout) * time.Millisecond
+	}
+
+	req.Options = opts
+	return nil
+}
+
+func (udpProber) Probe(ctx context.Context, req HttpRequest) ProbeResult {
+	return probeUDP(ctx, req)
+}
+
+// probeUDP sends one datagram on a connected socket. Without an expected
+// reply the port counts as up unless the kernel surfaces an ICMP
+// port-unreachable (ECONNREFUSED) before the read deadline.
+func probeUDP(ctx context.Context, req HttpRequest) ProbeResult {
+	opts, _ := req.Options.(*udpOptions)
+	if opts == nil {
+		opts = &udpOptions{readTimeout: defaultReadTimeout}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	start := time.Now()
+
+	dialer := net.Dialer{Timeout: defaultTimeout}
+	conn, err := dialer.DialContext(ctx, "udp", req.Host)
+	if err != nil {
+		return newProbeResult(req, hr.Down, err.Error())
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(opts.payload); err != nil {
+		return newProbeResult(req, hr.Down, udpError("write failed", err))
+	}
+	sent := time.Since(start)
+
+	deadline := time.Now().Add(opts.readTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetReadDeadline(deadline)
+
+	var last []byte
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				if opts.expect != nil && last != nil {
+					return newProbeResult(req, hr.Down, fmt.Sprintf("unexpected reply %q", truncate(string(last), 120)))
+				}
+				if opts.expect != nil {
+					return newProbeResult(req, hr.Down, fmt.Sprintf("no reply within %s", opts.readTimeout))
+				}
+				// Nothing to time but the send itself.
+				res := newProbeResult(req, hr.Up, "datagram sent, no ICMP error")
+				res.ResponseTime = millis(sent)
+				return res
+			}
+			return newProbeResult(req, hr.Down, udpError("read failed", err))
+		}
+
+		reply := buf[:n]
+		if opts.expect == nil || opts.expect.match(reply) {
+			res := newProbeResult(req, hr.Up, fmt.Sprintf("reply received %s", truncate(strings.TrimSpace(string(reply)), 120)))
+			res.ResponseTime = millis(time.Since(start))
+			return res
+		}
+		// Not the reply we expect: keep reading until the deadline.
+		last = append(last[:0], reply...)
+	}
+}
+
+func udpError(prefix string, err error) string {
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return "port unreachable (ICMP)"
+	}
+	if errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) {
+		return "host unreachable (ICMP)"
+	}
+	var sysErr *os.SyscallError
+	if errors.As(err, &sysErr) {
+		return fmt.Sprintf("%s: %s", prefix, sysErr.Err.Error())
+	}
+	return fmt.Sprintf("%s: %s", prefix, err.Error())
+}
@@ -0,0 +1,225 @@
+//go:build !js
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// -------------------- WEBSOCKET PROBE --------------------
+
+type wsOptions struct {
+	Subprotocols []string `json:"subprotocols"`
+	Send         string   `json:"send"`
+	Expect       string   `json:"expect"`
+	ExpectMode   string   `json:"expectMode"`
+	ReadTimeout  int64    `json:"readTimeout"`
+
+	expect      *expectation
+	readTimeout time.Duration
+}
+
+type wsProber struct{}
+
+func init() {
+	RegisterProber(wsProber{}, "ws", "wss")
+}
+
+func (wsProber) Prepare(req *HttpRequest) error {
+	if _, err := url.Parse(fmt.Sprintf("%s://%s", normalizeProtocol(req.Protocol), req.Host)); err != nil {
+		return err
+	}
+
+	switch req.authType() {
+	case AuthBasic:
+		if req.Username == "" {
+			return errors.New("basic auth requires a username")
+		}
+	case AuthBearer:
+		if req.Token == "" {
+			return errors.New("bearer auth requires a token")
+		}
+	case AuthDigest:
+		return errors.New("digest auth is not supported for websocket monitors")
+	case "":
+		if req.AuthType != "" {
+			return fmt.Errorf("unsupported auth type %q", req.AuthType)
+		}
+	}
+
+	opts := &wsOptions{}
+	if err := decodeConfig(req, opts); err != nil {
+		return err
+	}
+
+	var err error
+	if opts.expect, err = newExpectation(opts.Expect, opts.ExpectMode); err != nil {
+		return err
+	}
+	if opts.expect != nil && opts.Send == "" {
+		return errors.New("expect needs a message to send")
+	}
+
+	opts.readTimeout = defaultReadTimeout
+	if opts.ReadTimeout > 0 {
+		opts.readTimeout = time.Duration(opts.ReadTimeout) * time.Millisecond
+	}
+
+	req.Options = opts
+	return nil
+}
+
+func (wsProber) Probe(ctx context.Context, req HttpRequest) ProbeResult {
+	return probeWebSocket(ctx, req)
+}
+
+// probeWebSocket performs the upgrade handshake and, when a message is
+// configured, waits for the first reply that meets the expectation.
+func probeWebSocket(ctx context.Context, req HttpRequest) ProbeResult {
+	opts, _ := req.Options.(*wsOptions)
+	if opts == nil {
+		opts = &wsOptions{readTimeout: defaultReadTimeout}
+	}
+
+	target := fmt.Sprintf("%s://%s", normalizeProtocol(req.Protocol), req.Host)
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	timer := newPhaseTimer()
+	traced := httptrace.WithClientTrace(ctx, timer.trace())
+
+	header := http.Header{}
+	host := ""
+	for key, value := range req.Headers {
+		if strings.EqualFold(key, "Host") {
+			host = value
+			continue
+		}
+		header.Set(key, value)
+	}
+
+	switch {
+	case req.UserAgent != "":
+		header.Set("User-Agent", req.UserAgent)
+	case header.Get("User-Agent") == "" && userAgent != "":
+		header.Set("User-Agent", userAgent)
+	}
+
+	switch req.authType() {
+	case AuthBasic:
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(req.Username+":"+req.Password)))
+	case AuthBearer:
+		header.Set("Authorization", "Bearer "+req.Token)
+	}
+
+	var tlsInfo TLSInfo
+	u, _ := url.Parse(target)
+	client := probeClient(u.Hostname(), &tlsInfo)
+	if host != "" {
+		client.Transport = hostRoundTripper{host: host, next: client.Transport}
+	}
+
+	conn, resp, err := websocket.Dial(traced, target, &websocket.DialOptions{
+		HTTPClient:   client,
+		HTTPHeader:   header,
+		Subprotocols: opts.Subprotocols,
+	})
+	handshakeDone := time.Now()
+	timings := timer.timings(handshakeDone)
+
+	if err != nil {
+		description := fmt.Sprintf("%s - handshake failed: %s", req.Host, err.Error())
+		if resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+			description = fmt.Sprintf("%s - %d - upgrade refused", req.Host, resp.StatusCode)
+		}
+		res := newProbeResult(req, hr.Down, description)
+		res.Timings = timings
+		res.ResponseTime = timings.Total
+		if len(tlsInfo.Chain) > 0 {
+			res.TLS = &tlsInfo
+		}
+		return res
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	conn.SetReadLimit(maxBodySize)
+
+	metrics := map[string]float64{"handshake_time": timings.Total}
+	state, description := hr.Up, fmt.Sprintf("%s - upgraded", req.Host)
+	if sub := conn.Subprotocol(); sub != "" {
+		description = fmt.Sprintf("%s (%s)", description, sub)
+	}
+
+	if opts.Send != "" {
+		rtt, reply, err := wsRoundTrip(ctx, conn, opts)
+		switch {
+		case err == nil:
+			metrics["message_rtt"] = millis(rtt)
+			description = fmt.Sprintf("%s - reply received %s", description, truncate(strings.TrimSpace(string(reply)), 120))
+		case reply != nil:
+			state, description = hr.Down, fmt.Sprintf("%s - unexpected reply %q: %s", description, truncate(string(reply), 120), err.Error())
+		default:
+			state, description = hr.Down, fmt.Sprintf("%s - %s", description, err.Error())
+		}
+	}
+
+	state, description = applyCertExpiry(req, &tlsInfo, state, description)
+
+	res := newProbeResult(req, state, description)
+	res.Timings = timings
+	res.Metrics = metrics
+	res.ResponseTime = millis(time.Since(timer.start))
+	if len(tlsInfo.Chain) > 0 {
+		res.TLS = &tlsInfo
+	}
+	return res
+}
+
+// wsRoundTrip sends the configured message and reads until a reply matches,
+// returning the last message seen when none did.
+func wsRoundTrip(ctx context.Context, conn *websocket.Conn, opts *wsOptions) (time.Duration, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, opts.readTimeout)
+	defer cancel()
+
+	sent := time.Now()
+	if err := conn.Write(ctx, websocket.MessageText, []byte(opts.Send)); err != nil {
+		return 0, nil, fmt.Errorf("write failed: %w", err)
+	}
+
+	var last []byte
+	for {
+		_, msg, err := conn.Read(ctx)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				err = fmt.Errorf("no matching reply within %s", opts.readTimeout)
+			}
+			return 0, last, err
+		}
+		if opts.expect == nil || opts.expect.match(msg) {
+			return time.Since(sent), msg, nil
+		}
+		last = msg
+	}
+}
+
+// hostRoundTripper overrides the Host header, which http.Header cannot.
+type hostRoundTripper struct {
+	host string
+	next http.RoundTripper
+}
+
+func (h hostRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Host = h.host
+	return h.next.RoundTrip(r)
+}
@@ -0,0 +1,28 @@
+//go:build js
+
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// -------------------- WEBSOCKET PROBE --------------------
+
+// On js/wasm coder/websocket dials through the browser's WebSocket, which
+// takes neither a custom HTTP client nor headers, so ws and wss monitors are
+// rejected in that build.
+
+type wsProber struct{}
+
+func init() {
+	RegisterProber(wsProber{}, "ws", "wss")
+}
+
+func (wsProber) Prepare(req *HttpRequest) error {
+	return fmt.Errorf("%w: %s monitors are not available in the wasm build", ErrUnsupportedProtocol, normalizeProtocol(req.Protocol))
+}
+
+func (wsProber) Probe(_ context.Context, req HttpRequest) ProbeResult {
+	return newProbeResult(req, hr.Down, "websocket monitors are not available in the wasm build")
+}
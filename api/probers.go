@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// -------------------- PROBER REGISTRY --------------------
+
+// Prober checks one protocol. Prepare runs once when a monitor is loaded: it
+// decodes the protocol specific config into req.Options and rejects monitors
+// that can never succeed. Probe then runs on every tick.
+type Prober interface {
+	Prepare(req *HttpRequest) error
+	Probe(ctx context.Context, req HttpRequest) ProbeResult
+}
+
+var ErrUnsupportedProtocol = errors.New("unsupported protocol")
+
+// pushProber is a Prober whose monitors are fed by check-ins instead of
+// reaching out to a host, so they need neither a host nor a response time.
+type pushProber interface {
+	Prober
+	pushed()
+}
+
+// releaser is a Prober keeping state for its monitors outside their worker.
+// release is called when a worker stops. What it lets go of can still be
+// taken over by the monitor's next version until sweep runs, at the end of
+// each reconcile pass.
+type releaser interface {
+	Prober
+	release(req HttpRequest)
+	sweep()
+}
+
+var probers = struct {
+	sync.RWMutex
+	m map[string]Prober
+}{m: make(map[string]Prober)}
+
+func RegisterProber(p Prober, protocols ...string) {
+	probers.Lock()
+	defer probers.Unlock()
+	for _, protocol := range protocols {
+		probers.m[normalizeProtocol(protocol)] = p
+	}
+}
+
+func lookupProber(protocol string) (Prober, error) {
+	probers.RLock()
+	defer probers.RUnlock()
+	p, ok := probers.m[normalizeProtocol(protocol)]
+	if !ok {
+		return nil, fmt.Errorf("%w %q, expected one of %s", ErrUnsupportedProtocol, protocol, strings.Join(registeredProtocols(), ", "))
+	}
+	return p, nil
+}
+
+func sweepProbers() {
+	probers.RLock()
+	defer probers.RUnlock()
+	for _, p := range probers.m {
+		if r, ok := p.(releaser); ok {
+			r.sweep()
+		}
+	}
+}
+
+// registeredProtocols expects probers to be locked by the caller.
+func registeredProtocols() []string {
+	out := make([]string, 0, len(probers.m))
+	for protocol := range probers.m {
+		out = append(out, protocol)
+	}
+	slices.Sort(out)
+	return out
+}
+
+func normalizeProtocol(protocol string) string {
+	return strings.ToLower(strings.TrimSpace(protocol))
+}
+
+// prepareTarget resolves the prober for req and validates it.
+func prepareTarget(req *HttpRequest) (Prober, error) {
+	prober, err := lookupProber(req.Protocol)
+	if err != nil {
+		return nil, err
+	}
+	if _, push := prober.(pushProber); !push && strings.TrimSpace(req.Host) == "" {
+		return nil, errors.New("host is required")
+	}
+	if err := prober.Prepare(req); err != nil {
+		return nil, err
+	}
+	return prober, nil
+}
+
+// decodeConfig strictly decodes the monitor's "config" object into opts, so
+// a typo in a field name is reported instead of silently ignored.
+func decodeConfig(req *HttpRequest, opts any) error {
+	if len(bytes.TrimSpace(req.Config)) == 0 || string(bytes.TrimSpace(req.Config)) == "null" {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(req.Config))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(opts); err != nil {
+		return fmt.Errorf("invalid %s config: %w", normalizeProtocol(req.Protocol), err)
+	}
+	return nil
+}
+
+// -------------------- TARGET ERRORS --------------------
+
+// Target errors are served on /v1/status for the dashboard, with the
+// monitor's credentials redacted.
+
+type TargetError struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Protocol string `json:"protocol"`
+	Error    string `json:"error"`
+}
+
+var targetErrors = struct {
+	sync.RWMutex
+	list []TargetError
+}{}
+
+func setTargetErrors(list []TargetError) {
+	targetErrors.Lock()
+	defer targetErrors.Unlock()
+	targetErrors.list = list
+}
+
+func getTargetErrors() []TargetError {
+	targetErrors.RLock()
+	defer targetErrors.RUnlock()
+	return slices.Clone(targetErrors.list)
+}
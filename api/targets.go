@@ -0,0 +1,788 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	convex "github.com/inselfcontroll/convex-go"
+	"github.com/nats-io/nats.go"
+	"sigs.k8s.io/yaml"
+)
+
+// -------------------- TARGET REGISTRY --------------------
+
+// The target registry holds the monitors as last fetched by the probe
+// manager, so handlers never query the database themselves. Its version goes
+// up whenever the list changes, which tells SSE clients their indexes moved.
+
+type targetRegistry struct {
+	sync.RWMutex
+	version uint64
+	list    []HttpRequest
+	index   map[string]int
+	// staleSince is when reloading the monitors started failing.
+	staleSince time.Time
+}
+
+var targets = &targetRegistry{index: make(map[string]int)}
+
+// update replaces the list and reports whether it changed.
+func (r *targetRegistry) update(list []HttpRequest) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.version > 0 && reflect.DeepEqual(r.list, list) {
+		return false
+	}
+
+	r.list = slices.Clone(list)
+	r.index = make(map[string]int, len(list))
+	for i, t := range list {
+		r.index[t.ID] = i
+	}
+	r.version++
+	return true
+}
+
+// Index is the position on the status page of the monitor with id.
+func (r *targetRegistry) Index(id string) (int, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	i, ok := r.index[id]
+	return i, ok
+}
+
+func (r *targetRegistry) Len() int {
+	r.RLock()
+	defer r.RUnlock()
+	return len(r.list)
+}
+
+func (r *targetRegistry) Version() uint64 {
+	r.RLock()
+	defer r.RUnlock()
+	return r.version
+}
+
+func (r *targetRegistry) setStale(stale bool) {
+	r.Lock()
+	defer r.Unlock()
+	switch {
+	case !stale:
+		r.staleSince = time.Time{}
+	case r.staleSince.IsZero():
+		r.staleSince = time.Now()
+	}
+}
+
+// Stale reports since when the list could not be reloaded, if it is stale.
+func (r *targetRegistry) Stale() (time.Time, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	return r.staleSince, !r.staleSince.IsZero()
+}
+
+// -------------------- TARGET SOURCES --------------------
+
+// TargetSource loads monitor definitions. A monitor's ID keys its state, so
+// it must be unique across all sources and stay the same across edits.
+type TargetSource interface {
+	Name() string
+	Targets(ctx context.Context) ([]HttpRequest, error)
+}
+
+// changeNotifier is a TargetSource that can tell when its monitors changed,
+// so the probe manager reloads them without waiting for its next pass.
+type changeNotifier interface {
+	Changes(ctx context.Context) <-chan struct{}
+}
+
+// targetSourceFromEnv builds the sources listed in TARGET_SOURCES, "convex"
+// and "file", separated by commas. Without it every configured source is
+// used.
+func targetSourceFromEnv() (TargetSource, error) {
+	var names []string
+	if list := strings.TrimSpace(os.Getenv("TARGET_SOURCES")); list != "" {
+		names = strings.Split(list, ",")
+	} else {
+		if os.Getenv("CONVEX_DB_URL") != "" {
+			names = append(names, "convex")
+		}
+		if os.Getenv("TARGETS_FILE") != "" {
+			names = append(names, "file")
+		}
+	}
+
+	var sources []TargetSource
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "convex":
+			if os.Getenv("CONVEX_DB_URL") == "" || os.Getenv("API_KEY") == "" {
+				return nil, errors.New("the convex monitor source needs CONVEX_DB_URL and API_KEY")
+			}
+			snapshot, err := newSnapshotSource(&breakerSource{TargetSource: convexSource{apiKey: os.Getenv("API_KEY")}})
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, snapshot)
+		case "file":
+			if os.Getenv("TARGETS_FILE") == "" {
+				return nil, errors.New("the file monitor source needs TARGETS_FILE")
+			}
+			sources = append(sources, fileSource{path: os.Getenv("TARGETS_FILE")})
+		default:
+			return nil, fmt.Errorf("unknown monitor source %q, expected convex or file", name)
+		}
+	}
+
+	switch len(sources) {
+	case 0:
+		return nil, errors.New("no monitor source configured, set CONVEX_DB_URL and API_KEY or TARGETS_FILE")
+	case 1:
+		return sources[0], nil
+	}
+	return mergedSource(sources), nil
+}
+
+// monitorRow is a monitor as stored in the status table. Intervals are in
+// seconds and latencies in milliseconds.
+type monitorRow struct {
+	ID          string            `json:"_id"`
+	Name        string            `json:"name"`
+	Paused      bool              `json:"paused"`
+	Protocol    string            `json:"protocol"`
+	Host        string            `json:"host"`
+	Interval    int64             `json:"interval"`
+	Assertions  []Assertion       `json:"assertions"`
+	Method      string            `json:"method"`
+	Headers     map[string]string `json:"headers"`
+	Body        string            `json:"body"`
+	ContentType string            `json:"contentType"`
+	UserAgent   string            `json:"userAgent"`
+	Username    string            `json:"username"`
+	Password    string            `json:"password"`
+	AuthType    string            `json:"authType"`
+	Token       string            `json:"token"`
+	CertExpiry  int               `json:"certExpiryDays"`
+
+	WarnLatency    int64   `json:"warnLatency"`
+	DownLatency    int64   `json:"downLatency"`
+	DegradedWeight float64 `json:"degradedWeight"`
+
+	FailAfter    int `json:"failAfter"`
+	RecoverAfter int `json:"recoverAfter"`
+
+	Config json.RawMessage `json:"config"`
+}
+
+func (u monitorRow) request() HttpRequest {
+	return HttpRequest{
+		ID:          u.ID,
+		Name:        u.Name,
+		Paused:      u.Paused,
+		Protocol:    u.Protocol,
+		Host:        u.Host,
+		Interval:    time.Duration(u.Interval) * time.Second,
+		Assertions:  u.Assertions,
+		Method:      strings.ToUpper(strings.TrimSpace(u.Method)),
+		Headers:     u.Headers,
+		Body:        u.Body,
+		ContentType: u.ContentType,
+		UserAgent:   u.UserAgent,
+		Username:    u.Username,
+		Password:    u.Password,
+		AuthType:    u.AuthType,
+		Token:       u.Token,
+		CertExpiry:  u.CertExpiry,
+
+		WarnLatency:    time.Duration(u.WarnLatency) * time.Millisecond,
+		DownLatency:    time.Duration(u.DownLatency) * time.Millisecond,
+		DegradedWeight: min(max(u.DegradedWeight, 0), 1),
+
+		FailAfter:    max(u.FailAfter, 1),
+		RecoverAfter: max(u.RecoverAfter, 1),
+
+		Config: u.Config,
+	}
+}
+
+// -------------------- CONVEX SOURCE --------------------
+
+type convexSource struct {
+	apiKey string
+}
+
+func (convexSource) Name() string { return "convex" }
+
+func (s convexSource) Targets(ctx context.Context) ([]HttpRequest, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	args := map[string]any{
+		"apiKey": s.apiKey,
+	}
+
+	rows, err := convex.Query[[]monitorRow](ctx, convexClient, "status:get", args)
+	if err != nil {
+		if convexErr, ok := convex.IsConvexError(err); ok {
+			return nil, fmt.Errorf("convex error: %s", convexErr.Message)
+		}
+		return nil, err
+	}
+
+	out := make([]HttpRequest, 0, len(rows))
+	for _, u := range rows {
+		out = append(out, u.request())
+	}
+	return out, nil
+}
+
+// -------------------- FILE SOURCE --------------------
+
+// fileWatchInterval is how often the monitors file is checked for changes.
+const fileWatchInterval = 2 * time.Second
+
+var (
+	envReference  = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+	fileMonitorID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// fileSource reads monitors from a YAML or JSON file holding a list of them,
+// or a "monitors" key with the list. Fields are those of the status table,
+// plus "id"; without one the ID is derived from the name, so a rename loses
+// the history. ${NAME} in a string value is replaced with the environment
+// variable NAME to keep secrets out of the file; it is expanded once the file
+// is parsed, so the value is never read as YAML.
+type fileSource struct {
+	path string
+}
+
+type fileMonitor struct {
+	ID string `json:"id"`
+	monitorRow
+}
+
+func (fileSource) Name() string { return "file" }
+
+func (s fileSource) Targets(context.Context) ([]HttpRequest, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	out, err := parseMonitorsFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+	return out, nil
+}
+
+// Changes polls the file, which also catches editors that replace it.
+func (s fileSource) Changes(ctx context.Context) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	last := s.stamp()
+	go func() {
+		ticker := time.NewTicker(fileWatchInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if stamp := s.stamp(); stamp != last {
+					last = stamp
+					select {
+					case ch <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+func (s fileSource) stamp() [2]int64 {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return [2]int64{}
+	}
+	return [2]int64{info.ModTime().UnixNano(), info.Size()}
+}
+
+func parseMonitorsFile(data []byte) ([]HttpRequest, error) {
+	doc, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	if doc, err = expandEnvReferences(doc); err != nil {
+		return nil, err
+	}
+	doc = bytes.TrimSpace(doc)
+	if len(doc) == 0 || string(doc) == "null" {
+		return []HttpRequest{}, nil
+	}
+
+	if doc[0] != '[' {
+		var wrapped struct {
+			Monitors json.RawMessage `json:"monitors"`
+		}
+		if err := strictUnmarshal(doc, &wrapped); err != nil {
+			return nil, err
+		}
+		if len(wrapped.Monitors) == 0 || string(wrapped.Monitors) == "null" {
+			return []HttpRequest{}, nil
+		}
+		doc = wrapped.Monitors
+	}
+
+	var rows []fileMonitor
+	if err := strictUnmarshal(doc, &rows); err != nil {
+		return nil, err
+	}
+
+	out := make([]HttpRequest, 0, len(rows))
+	seen := make(map[string]bool, len(rows))
+	for i, row := range rows {
+		if strings.TrimSpace(row.Name) == "" {
+			return nil, fmt.Errorf("monitor %d has no name", i+1)
+		}
+
+		switch {
+		case row.ID != "":
+		case row.monitorRow.ID != "":
+			row.ID = row.monitorRow.ID
+		default:
+			sum := sha256.Sum256([]byte(row.Name))
+			row.ID = "file_" + hex.EncodeToString(sum[:8])
+		}
+		if !fileMonitorID.MatchString(row.ID) {
+			return nil, fmt.Errorf("%s: id %q may only hold letters, digits, _ and -", row.Name, row.ID)
+		}
+		if seen[row.ID] {
+			return nil, fmt.Errorf("%s: id %q is used by another monitor", row.Name, row.ID)
+		}
+		seen[row.ID] = true
+
+		row.monitorRow.ID = row.ID
+		out = append(out, row.request())
+	}
+	return out, nil
+}
+
+// expandEnvReferences replaces ${NAME} in the string values of doc.
+func expandEnvReferences(doc []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(expandEnv(v))
+}
+
+func expandEnv(v any) any {
+	switch node := v.(type) {
+	case string:
+		return envReference.ReplaceAllStringFunc(node, func(m string) string {
+			return os.Getenv(envReference.FindStringSubmatch(m)[1])
+		})
+	case map[string]any:
+		for key, value := range node {
+			node[key] = expandEnv(value)
+		}
+	case []any:
+		for i, value := range node {
+			node[i] = expandEnv(value)
+		}
+	}
+	return v
+}
+
+func strictUnmarshal(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// -------------------- MERGED SOURCE --------------------
+
+// mergedSource lists the monitors of each source in turn. It fails when any
+// source does, so that one being unreachable never reads as its monitors
+// having been deleted.
+type mergedSource []TargetSource
+
+func (m mergedSource) Name() string {
+	names := make([]string, len(m))
+	for i, s := range m {
+		names[i] = s.Name()
+	}
+	return strings.Join(names, "+")
+}
+
+func (m mergedSource) Targets(ctx context.Context) ([]HttpRequest, error) {
+	var out []HttpRequest
+	var stale *staleTargets
+	owner := make(map[string]string)
+	for _, s := range m {
+		list, err := s.Targets(ctx)
+		var fallback *staleTargets
+		switch {
+		case errors.As(err, &fallback):
+			list = fallback.list
+			if stale == nil {
+				stale = &staleTargets{saved: fallback.saved, err: fmt.Errorf("%s: %w", s.Name(), fallback.err)}
+			}
+		case err != nil:
+			return nil, fmt.Errorf("%s: %w", s.Name(), err)
+		}
+		for _, t := range list {
+			if other, ok := owner[t.ID]; ok {
+				slog.Warn("Skipping monitor with duplicate ID", "id", t.ID, "name", t.Name, "source", s.Name(), "first", other)
+				continue
+			}
+			owner[t.ID] = s.Name()
+			out = append(out, t)
+		}
+	}
+
+	if stale != nil {
+		stale.list = out
+		return nil, stale
+	}
+	return out, nil
+}
+
+func (m mergedSource) Changes(ctx context.Context) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	for _, s := range m {
+		n, ok := s.(changeNotifier)
+		if !ok {
+			continue
+		}
+		go func(changes <-chan struct{}) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-changes:
+					select {
+					case ch <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}(n.Changes(ctx))
+	}
+	return ch
+}
+
+// -------------------- LAST KNOWN GOOD --------------------
+
+// The monitors a source last returned are kept in the BEEP_TARGETS bucket,
+// so that a restart while the source is unreachable still probes them.
+// Credentials are sealed with AES-GCM under a key derived from
+// TARGETS_SNAPSHOT_KEY. Without the key they are left out of the snapshot,
+// and the monitors that need them report unknown until the source answers
+// again.
+
+const targetsBucket = "BEEP_TARGETS"
+
+// staleTargets is the error of a source that failed but still has a last
+// known good list to offer.
+type staleTargets struct {
+	list  []HttpRequest
+	saved time.Time
+	err   error
+}
+
+func (e *staleTargets) Error() string {
+	return fmt.Sprintf("%s (last known good list from %s)", e.err, e.saved.UTC().Format(time.RFC3339))
+}
+
+func (e *staleTargets) Unwrap() error { return e.err }
+
+type targetSnapshot struct {
+	Saved    time.Time         `json:"saved"`
+	Monitors []snapshotMonitor `json:"monitors"`
+}
+
+type snapshotMonitor struct {
+	HttpRequest
+	// Credentials is set when secrets were stripped from the monitor, and
+	// Sealed holds them encrypted when a snapshot key is set.
+	Credentials bool   `json:"credentials,omitempty"`
+	Sealed      string `json:"sealed,omitempty"`
+}
+
+// sealedSecrets are the fields of a monitor that may carry its credentials.
+type sealedSecrets struct {
+	Password string            `json:"password,omitempty"`
+	Token    string            `json:"token,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Host     string            `json:"host,omitempty"`
+	Body     string            `json:"body,omitempty"`
+	Config   json.RawMessage   `json:"config,omitempty"`
+}
+
+type snapshotSource struct {
+	TargetSource
+
+	// aead seals the credentials, nil when no snapshot key is set.
+	aead cipher.AEAD
+
+	mu    sync.Mutex
+	last  []HttpRequest
+	saved time.Time
+	// stored is the last list written, to skip rewriting it unchanged.
+	stored []HttpRequest
+}
+
+func newSnapshotSource(source TargetSource) (*snapshotSource, error) {
+	s := &snapshotSource{TargetSource: source}
+
+	key := os.Getenv("TARGETS_SNAPSHOT_KEY")
+	if key == "" {
+		return s, nil
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	if s.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *snapshotSource) Targets(ctx context.Context) ([]HttpRequest, error) {
+	list, err := s.TargetSource.Targets(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err == nil {
+		s.last, s.saved = list, time.Now()
+		s.save(ctx, list)
+		return list, nil
+	}
+
+	if s.last == nil {
+		last, saved, loadErr := s.load(ctx)
+		if loadErr != nil {
+			return nil, fmt.Errorf("%w (no last known good list: %s)", err, loadErr.Error())
+		}
+		s.last, s.saved = last, saved
+	}
+	return nil, &staleTargets{list: slices.Clone(s.last), saved: s.saved, err: err}
+}
+
+// save expects s to be locked by the caller.
+func (s *snapshotSource) save(ctx context.Context, list []HttpRequest) {
+	if reflect.DeepEqual(list, s.stored) {
+		return
+	}
+	if targetsKV == nil || nc.Status() != nats.CONNECTED {
+		return
+	}
+
+	monitors := make([]snapshotMonitor, 0, len(list))
+	for _, t := range list {
+		m, err := s.strip(t)
+		if err != nil {
+			slog.Error("Failed to seal monitor credentials", "id", t.ID, "name", t.Name, "error", err)
+		}
+		monitors = append(monitors, m)
+	}
+
+	snapshot, _ := json.Marshal(targetSnapshot{Saved: time.Now().UTC(), Monitors: monitors})
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(snapshot)
+	gz.Close()
+
+	if _, err := targetsKV.Put(ctx, s.Name(), buf.Bytes()); err != nil {
+		slog.Error("Failed to save monitors snapshot", "source", s.Name(), "error", err)
+		return
+	}
+	s.stored = list
+}
+
+func (s *snapshotSource) load(ctx context.Context) ([]HttpRequest, time.Time, error) {
+	if targetsKV == nil || nc.Status() != nats.CONNECTED {
+		return nil, time.Time{}, errors.New("NATS not connected")
+	}
+
+	entry, err := targetsKV.Get(ctx, s.Name())
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(entry.Value()))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer gr.Close()
+
+	var snapshot targetSnapshot
+	if err := json.NewDecoder(gr).Decode(&snapshot); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	out := make([]HttpRequest, 0, len(snapshot.Monitors))
+	for _, m := range snapshot.Monitors {
+		t := m.HttpRequest
+		if m.Credentials {
+			if err := s.unseal(&t, m.Sealed); err != nil {
+				slog.Warn("Monitor credentials unavailable from the snapshot", "id", t.ID, "name", t.Name, "error", err)
+				t.credentialsMissing = true
+			}
+		}
+		out = append(out, t)
+	}
+	return out, snapshot.Saved, nil
+}
+
+// strip leaves the monitor's credentials out of its snapshot, sealed when a
+// key is set. Password and Token are never marshalled in the clear.
+func (s *snapshotSource) strip(t HttpRequest) (snapshotMonitor, error) {
+	secrets := t.secrets()
+	if len(secrets) == 0 {
+		return snapshotMonitor{HttpRequest: t}, nil
+	}
+
+	m := snapshotMonitor{Credentials: true}
+	var err error
+	if s.aead != nil {
+		m.Sealed, err = s.seal(t)
+	}
+
+	headers := make(map[string]string, len(t.Headers))
+	for key, value := range t.Headers {
+		if !isSensitiveHeader(key) {
+			headers[key] = redact(value, secrets)
+		}
+	}
+	t.Headers = headers
+	t.Host = redact(t.Host, secrets)
+	t.Body = redact(t.Body, secrets)
+	t.Config = json.RawMessage(redact(string(t.Config), secrets))
+	m.HttpRequest = t
+	return m, err
+}
+
+func (s *snapshotSource) seal(t HttpRequest) (string, error) {
+	plain, err := json.Marshal(sealedSecrets{
+		Password: t.Password,
+		Token:    t.Token,
+		Headers:  t.Headers,
+		Host:     t.Host,
+		Body:     t.Body,
+		Config:   t.Config,
+	})
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(s.aead.Seal(nonce, nonce, plain, []byte(t.ID))), nil
+}
+
+// unseal restores the credentials strip sealed into the monitor.
+func (s *snapshotSource) unseal(t *HttpRequest, sealed string) error {
+	if s.aead == nil {
+		return errors.New("no TARGETS_SNAPSHOT_KEY set")
+	}
+	if sealed == "" {
+		return errors.New("saved without a snapshot key")
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < s.aead.NonceSize() {
+		return errors.New("malformed sealed credentials")
+	}
+	nonce, data := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, data, []byte(t.ID))
+	if err != nil {
+		return errors.New("sealed with another snapshot key")
+	}
+
+	var secrets sealedSecrets
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return err
+	}
+	t.Password, t.Token = secrets.Password, secrets.Token
+	t.Headers, t.Host, t.Body, t.Config = secrets.Headers, secrets.Host, secrets.Body, secrets.Config
+	return nil
+}
+
+// -------------------- CIRCUIT BREAKER --------------------
+
+const (
+	breakerThreshold  = 3
+	breakerMinBackoff = 30 * time.Second
+	breakerMaxBackoff = 10 * time.Minute
+)
+
+var errCircuitOpen = errors.New("circuit open")
+
+// breakerSource stops calling a source after breakerThreshold failures in a
+// row, waiting twice as long after each further failure, so that an outage
+// is not hit on every reconcile pass.
+type breakerSource struct {
+	TargetSource
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func (b *breakerSource) Targets(ctx context.Context) ([]HttpRequest, error) {
+	b.mu.Lock()
+	if until := b.openUntil; time.Now().Before(until) {
+		b.mu.Unlock()
+		return nil, fmt.Errorf("%s: %w until %s", b.Name(), errCircuitOpen, until.UTC().Format(time.RFC3339))
+	}
+	b.mu.Unlock()
+
+	list, err := b.TargetSource.Targets(ctx)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		if b.failures >= breakerThreshold {
+			slog.Info("Circuit closed", "source", b.Name())
+		}
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return list, nil
+	}
+
+	b.failures++
+	if b.failures >= breakerThreshold {
+		backoff := min(breakerMinBackoff<<min(b.failures-breakerThreshold, 10), breakerMaxBackoff)
+		b.openUntil = time.Now().Add(backoff)
+		slog.Warn("Circuit open", "source", b.Name(), "failures", b.failures, "retry_in", backoff.String())
+	}
+	return nil, err
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"math"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// -------------------- RESPONSE TIMES --------------------
+
+const responseHistorySize = 90
+
+type Timings struct {
+	DNS      float64 `json:"dns"`
+	Connect  float64 `json:"connect"`
+	TLS      float64 `json:"tls"`
+	TTFB     float64 `json:"ttfb"`
+	Transfer float64 `json:"transfer"`
+	Total    float64 `json:"total"`
+}
+
+type ResponseSample struct {
+	Timestamp    string  `json:"timestamp"`
+	ResponseTime float64 `json:"response_time"`
+}
+
+// phaseTimer collects httptrace timestamps for one probe. When a request is
+// retried (digest auth, redirects) the phases reflect the last connection.
+// Hooks can fire from other goroutines (parallel dials, say), hence the lock.
+type phaseTimer struct {
+	mu                  sync.Mutex
+	start               time.Time
+	dnsStart, dnsDone   time.Time
+	connStart, connDone time.Time
+	tlsStart, tlsDone   time.Time
+	firstByte           time.Time
+}
+
+func newPhaseTimer() *phaseTimer {
+	return &phaseTimer{start: time.Now()}
+}
+
+func (p *phaseTimer) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { p.mark(&p.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { p.mark(&p.dnsDone) },
+		ConnectStart:         func(string, string) { p.mark(&p.connStart) },
+		ConnectDone:          func(string, string, error) { p.mark(&p.connDone) },
+		TLSHandshakeStart:    func() { p.mark(&p.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { p.mark(&p.tlsDone) },
+		GotFirstResponseByte: func() { p.mark(&p.firstByte) },
+	}
+}
+
+func (p *phaseTimer) mark(t *time.Time) {
+	p.mu.Lock()
+	*t = time.Now()
+	p.mu.Unlock()
+}
+
+func (p *phaseTimer) timings(end time.Time) *Timings {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := &Timings{
+		DNS:     span(p.dnsStart, p.dnsDone),
+		Connect: span(p.connStart, p.connDone),
+		TLS:     span(p.tlsStart, p.tlsDone),
+		TTFB:    span(p.start, p.firstByte),
+		Total:   span(p.start, end),
+	}
+	if !p.firstByte.IsZero() {
+		t.Transfer = span(p.firstByte, end)
+	}
+	return t
+}
+
+func span(from, to time.Time) float64 {
+	if from.IsZero() || to.IsZero() || to.Before(from) {
+		return 0
+	}
+	return millis(to.Sub(from))
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// withResponseTime folds ms into the daily aggregates of a history entry,
+// continuing from prev when it is the same day's entry.
+func withResponseTime(entry, prev map[string]any, ms float64) map[string]any {
+	samples, _ := prev["response_samples"].(float64)
+	avg, _ := prev["avg_response_time"].(float64)
+	lo, hasMin := prev["min_response_time"].(float64)
+	hi, _ := prev["max_response_time"].(float64)
+
+	if ms <= 0 {
+		if samples > 0 {
+			entry["response_samples"] = samples
+			entry["avg_response_time"] = avg
+			entry["min_response_time"] = lo
+			entry["max_response_time"] = hi
+		}
+		return entry
+	}
+
+	if !hasMin || samples == 0 || ms < lo {
+		lo = ms
+	}
+	hi = max(hi, ms)
+	avg = (avg*samples + ms) / (samples + 1)
+
+	entry["response_samples"] = samples + 1
+	entry["avg_response_time"] = math.Round(avg*1000) / 1000
+	entry["min_response_time"] = lo
+	entry["max_response_time"] = hi
+	return entry
+}
+
+// applyLatencyThresholds degrades or fails a result that responded, but
+// slower than the monitor allows.
+func applyLatencyThresholds(req HttpRequest, res ProbeResult) ProbeResult {
+	if len(res.State) == 0 || res.State[0] == hr.Down || res.State[0] == hr.Unknown || res.ResponseTime <= 0 {
+		return res
+	}
+
+	elapsed := time.Duration(res.ResponseTime * float64(time.Millisecond))
+
+	switch {
+	case req.DownLatency > 0 && elapsed >= req.DownLatency:
+		res.State = []string{hr.Down}
+		res.Description = fmt.Sprintf("%s - response time %s exceeds %s", res.Description, elapsed.Round(time.Millisecond), req.DownLatency)
+	case req.WarnLatency > 0 && elapsed >= req.WarnLatency:
+		res.State = []string{hr.Warn}
+		res.Description = fmt.Sprintf("%s - degraded, response time %s exceeds %s", res.Description, elapsed.Round(time.Millisecond), req.WarnLatency)
+	}
+	return res
+}